@@ -0,0 +1,174 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+func isArchivePath(filePath string) bool {
+	switch strings.ToLower(path.Ext(filePath)) {
+	case ".cbz", ".zip":
+		return true
+	default:
+		return false
+	}
+}
+
+// A doujinSource is either a folder or a CBZ/ZIP archive containing a doujin.
+// Files are always accessed through `fsys`, so the import code doesn't need to
+// care about where the files come from.
+type doujinSource struct {
+	fsys fs.FS
+
+	// Absolute path of the folder or archive.
+	absolutePath string
+
+	// Path of the directory inside the archive that contains the doujin's
+	// files. Always "." for folders.
+	archiveRoot string
+
+	archive *zip.ReadCloser
+}
+
+func openDoujinSource(sourcePath string) (*doujinSource, error) {
+	absolutePath, err := filepath.Abs(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(absolutePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return &doujinSource{
+			fsys:         os.DirFS(absolutePath),
+			absolutePath: absolutePath,
+			archiveRoot:  ".",
+		}, nil
+	}
+
+	if !isArchivePath(absolutePath) {
+		return nil, fmt.Errorf("`%s` is neither a folder nor a CBZ/ZIP archive", sourcePath)
+	}
+
+	archive, err := zip.OpenReader(absolutePath)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to open archive `%s`: %w", sourcePath, err)
+	}
+
+	// Lots of archives wrap everything in a single top-level folder, so
+	// descend into it when the archive's root has nothing else.
+	archiveRoot := "."
+	for {
+		entries, err := fs.ReadDir(archive, archiveRoot)
+		if err != nil {
+			archive.Close()
+			return nil, fmt.Errorf("Failed to read archive `%s`: %w", sourcePath, err)
+		}
+
+		if len(entries) != 1 || !entries[0].IsDir() {
+			break
+		}
+
+		archiveRoot = path.Join(archiveRoot, entries[0].Name())
+	}
+
+	fsys, err := fs.Sub(archive, archiveRoot)
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	return &doujinSource{
+		fsys:         fsys,
+		absolutePath: absolutePath,
+		archiveRoot:  archiveRoot,
+		archive:      archive,
+	}, nil
+}
+
+func (source *doujinSource) IsArchive() bool {
+	return source.archive != nil
+}
+
+// Returns where a file of the doujin is located, in the form stored in the
+// DoujinPages table.
+func (source *doujinSource) PageFile(name string) PageFile {
+	if source.IsArchive() {
+		return PageFile{
+			Path:         source.absolutePath,
+			ArchiveEntry: path.Join(source.archiveRoot, name),
+		}
+	}
+
+	return PageFile{
+		Path:         filepath.Join(source.absolutePath, name),
+		ArchiveEntry: "",
+	}
+}
+
+func (source *doujinSource) Close() error {
+	if source.archive != nil {
+		return source.archive.Close()
+	}
+	return nil
+}
+
+// A PageFile is the location of a page. If ArchiveEntry is not empty, the
+// page is stored inside the archive at Path; otherwise Path is the page
+// itself.
+type PageFile struct {
+	Path         string
+	ArchiveEntry string
+}
+
+func (page PageFile) Name() string {
+	if page.ArchiveEntry != "" {
+		return page.ArchiveEntry
+	}
+	return page.Path
+}
+
+func (page PageFile) String() string {
+	if page.ArchiveEntry != "" {
+		return page.Path + ":" + page.ArchiveEntry
+	}
+	return page.Path
+}
+
+type archiveEntryReader struct {
+	io.ReadCloser
+	archive *zip.ReadCloser
+}
+
+func (r archiveEntryReader) Close() error {
+	return errors.Join(r.ReadCloser.Close(), r.archive.Close())
+}
+
+func (page PageFile) Open() (io.ReadCloser, error) {
+	if page.ArchiveEntry == "" {
+		return os.Open(page.Path)
+	}
+
+	archive, err := zip.OpenReader(page.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	entry, err := archive.Open(page.ArchiveEntry)
+	if err != nil {
+		archive.Close()
+		return nil, err
+	}
+
+	return archiveEntryReader{entry, archive}, nil
+}
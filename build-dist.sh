@@ -1,5 +1,5 @@
 #!/bin/sh
 
 CC=musl-gcc CGO_ENABLED=1 go build \
-            -tags "sqlite_omit_load_extension" \
+            -tags "sqlite_omit_load_extension sqlite_fts5" \
             -ldflags '-linkmode external -extldflags "-static"'
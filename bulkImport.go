@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+type BulkImportFailure struct {
+	Path  string `json:"path"`
+	Error string `json:"error"`
+}
+
+type BulkImportSuccess struct {
+	Path     string `json:"path"`
+	DoujinId int    `json:"doujin_id"`
+}
+
+type BulkImportReport struct {
+	Total           int                 `json:"total"`
+	Imported        int                 `json:"imported"`
+	Failed          int                 `json:"failed"`
+	DurationSeconds float64             `json:"duration_seconds"`
+	Successes       []BulkImportSuccess `json:"successes"`
+	Failures        []BulkImportFailure `json:"failures"`
+}
+
+// The result of filling in something missing from pages, like their hashes.
+// Updated is the number of pages that got it.
+type BackfillResult struct {
+	Updated  int
+	Failures []BulkImportFailure
+}
+
+type bulkImportResult struct {
+	index    int
+	doujinId int
+	err      error
+}
+
+// Imports every folder or archive in sourcePaths using at most `workers`
+// imports at a time. Failing imports don't stop the other ones; they are
+// listed in the returned report instead. `progress` is called after every
+// import with the number of finished imports.
+func (db *Database) BulkImport(sourcePaths []string, workers int, options ImportOptions, progress func(done int)) BulkImportReport {
+	startTime := time.Now()
+
+	jobs := make(chan int)
+	results := make(chan bulkImportResult)
+
+	var wg sync.WaitGroup
+	for range max(workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range jobs {
+				doujinId, err := db.ImportDoujin(sourcePaths[i], options)
+				results <- bulkImportResult{i, doujinId, err}
+			}
+		}()
+	}
+
+	go func() {
+		for i := range sourcePaths {
+			jobs <- i
+		}
+		close(jobs)
+		wg.Wait()
+		close(results)
+	}()
+
+	doujinIds := make([]int, len(sourcePaths))
+	errs := make([]error, len(sourcePaths))
+	done := 0
+	for result := range results {
+		doujinIds[result.index] = result.doujinId
+		errs[result.index] = result.err
+
+		done++
+		if progress != nil {
+			progress(done)
+		}
+	}
+
+	report := BulkImportReport{
+		Total:     len(sourcePaths),
+		Successes: []BulkImportSuccess{},
+		Failures:  []BulkImportFailure{},
+	}
+
+	for i, sourcePath := range sourcePaths {
+		if errs[i] != nil {
+			report.Failures = append(report.Failures, BulkImportFailure{sourcePath, errs[i].Error()})
+		} else {
+			report.Successes = append(report.Successes, BulkImportSuccess{sourcePath, doujinIds[i]})
+		}
+	}
+
+	report.Imported = len(report.Successes)
+	report.Failed = len(report.Failures)
+	report.DurationSeconds = time.Since(startTime).Seconds()
+
+	return report
+}
+
+// Prints the progress of a long operation to out, redrawing the same line
+// when out is a terminal and printing a new line every few seconds otherwise.
+type ProgressPrinter struct {
+	out        *os.File
+	total      int
+	startTime  time.Time
+	lastPrint  time.Time
+	isTerminal bool
+}
+
+func NewProgressPrinter(out *os.File, total int) *ProgressPrinter {
+	isTerminal := false
+	if info, err := out.Stat(); err == nil {
+		isTerminal = info.Mode()&os.ModeCharDevice != 0
+	}
+
+	return &ProgressPrinter{
+		out:        out,
+		total:      total,
+		startTime:  time.Now(),
+		isTerminal: isTerminal,
+	}
+}
+
+func (p *ProgressPrinter) Update(done int) {
+	interval := 5 * time.Second
+	if p.isTerminal {
+		interval = 200 * time.Millisecond
+	}
+
+	if done != p.total && time.Since(p.lastPrint) < interval {
+		return
+	}
+	p.lastPrint = time.Now()
+
+	elapsed := time.Since(p.startTime)
+	rate := float64(done) / elapsed.Seconds()
+
+	eta := "?"
+	if rate > 0 {
+		eta = time.Duration(float64(p.total-done) / rate * float64(time.Second)).Round(time.Second).String()
+	}
+
+	line := fmt.Sprintf("%d/%d done, %.1f/s, ETA %s", done, p.total, rate, eta)
+	if p.isTerminal {
+		fmt.Fprintf(p.out, "\r\033[K%s", line)
+		if done == p.total {
+			fmt.Fprintln(p.out)
+		}
+	} else {
+		fmt.Fprintln(p.out, line)
+	}
+}
+
+// Returns a progress callback for operations on pages that prints
+// "<action> <total> pages..." and then the progress, once there's something
+// to do.
+func newProgressCallback(action string) func(done int, total int) {
+	var progress *ProgressPrinter
+	return func(done int, total int) {
+		if total == 0 {
+			return
+		}
+		if progress == nil {
+			fmt.Fprintf(os.Stderr, "%s %d pages...\n", action, total)
+			progress = NewProgressPrinter(os.Stderr, total)
+		}
+		progress.Update(done)
+	}
+}
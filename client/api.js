@@ -249,6 +249,34 @@ export async function getPage(pageId) {
   return imgURL;
 }
 
+export async function getThumbnail(pageId) {
+  if (config.safe_mode) {
+    return makeSafeModeImage(480, 668, pageId);
+  }
+
+  const response = await fetch(config.api_url + "/api/v1/thumbnail", {
+    method: "POST",
+    headers: {
+      "Content-Type": "application/json",
+    },
+    body: JSON.stringify({ page_id: pageId }),
+  });
+
+  if (response.headers.get("Content-Type").includes("application/json")) {
+    let responseJson = await response.json();
+
+    // If the endpoint returned json, this should never be 0, but just in case
+    if (responseJson.error_code !== 0) {
+      error("Failed to get thumbnail: " + responseToErrorMsg(responseJson));
+    }
+    throw "Unreachable";
+  }
+
+  const blob = await response.blob();
+  const imgURL = URL.createObjectURL(blob);
+  return imgURL;
+}
+
 export async function needsLogin() {
   const response = await apiCall("/api/v1/needsLogin", { method: "POST" });
   if (response.error_code !== 0) {
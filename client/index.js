@@ -134,9 +134,9 @@ async function displaySearchResults(query, tags, antiTags, page) {
       expandedMeta.appendChild(readButton);
     }
 
-    const capePageId = entry.pages[0][1];
+    const capePageId = entry.pages[0].id;
     if (capePageId !== 0) {
-      await api.getPage(capePageId).then((pageImageURL) => {
+      await api.getThumbnail(capePageId).then((pageImageURL) => {
         searchResultImage.src = pageImageURL;
         expandedMetaImage.src = pageImageURL;
       });
@@ -12,13 +12,13 @@ await (async () => {
 
   const doujin = await api.getDoujin(doujinId);
 
-  const pages = doujin.pages.sort((a, b) => a[0] - b[0]);
+  const pages = doujin.pages.sort((a, b) => a.number - b.number);
   let currentPage = 0;
 
   const pageImage = document.getElementById("pageImage");
 
   const renderCurrentPage = async () => {
-    pageImage.src = await api.getPage(pages[currentPage][1]);
+    pageImage.src = await api.getPage(pages[currentPage].id);
   };
 
   pageImage.addEventListener("click", (e) => {
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/xml"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Subset of the ComicInfo.xml schema used by ComicRack, Komga, Kavita, etc.
+type ComicInfo struct {
+	XMLName     xml.Name        `xml:"ComicInfo"`
+	Title       string          `xml:"Title"`
+	Series      string          `xml:"Series,omitempty"`
+	Writer      string          `xml:"Writer,omitempty"`
+	Penciller   string          `xml:"Penciller,omitempty"`
+	Tags        string          `xml:"Tags,omitempty"`
+	Characters  string          `xml:"Characters,omitempty"`
+	Teams       string          `xml:"Teams,omitempty"`
+	LanguageISO string          `xml:"LanguageISO,omitempty"`
+	PageCount   int             `xml:"PageCount,omitempty"`
+	Year        int             `xml:"Year,omitempty"`
+	Month       int             `xml:"Month,omitempty"`
+	Day         int             `xml:"Day,omitempty"`
+	Pages       []ComicInfoPage `xml:"Pages>Page"`
+}
+
+type ComicInfoPage struct {
+	// Index of the page, starting at 0
+	Image int `xml:"Image,attr"`
+}
+
+var isoLanguageNames = map[string]string{
+	"ar": "arabic",
+	"de": "german",
+	"en": "english",
+	"es": "spanish",
+	"fr": "french",
+	"id": "indonesian",
+	"it": "italian",
+	"ja": "japanese",
+	"ko": "korean",
+	"pl": "polish",
+	"pt": "portuguese",
+	"ru": "russian",
+	"th": "thai",
+	"tr": "turkish",
+	"uk": "ukrainian",
+	"vi": "vietnamese",
+	"zh": "chinese",
+}
+
+func splitComicInfoList(lists ...string) []string {
+	values := []string{}
+	for _, list := range lists {
+		for _, value := range strings.Split(list, ",") {
+			value = strings.TrimSpace(value)
+			if value == "" || slices.Contains(values, value) {
+				continue
+			}
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+func comicInfoLanguage(languageISO string) []string {
+	// Codes can carry a region, like "en-US" or "pt_BR".
+	fields := strings.FieldsFunc(strings.TrimSpace(languageISO), func(r rune) bool {
+		return r == '-' || r == '_'
+	})
+	if len(fields) == 0 {
+		return []string{}
+	}
+
+	code := strings.ToLower(fields[0])
+
+	if name, ok := isoLanguageNames[code]; ok {
+		return []string{name}
+	}
+	return []string{code}
+}
+
+func (info ComicInfo) ToImportMetadata() DoujinImportMetadata {
+	title := info.Title
+	subtitle := info.Series
+	if title == "" {
+		title = info.Series
+		subtitle = ""
+	}
+	if subtitle == title {
+		subtitle = ""
+	}
+
+	var uploadDate time.Time
+	if info.Year > 0 {
+		month := max(info.Month, 1)
+		day := max(info.Day, 1)
+		uploadDate = time.Date(info.Year, time.Month(month), day, 0, 0, 0, 0, time.UTC)
+	}
+
+	pages := info.PageCount
+	if pages == 0 {
+		pages = len(info.Pages)
+	}
+
+	return DoujinImportMetadata{
+		Title:          title,
+		Subtitle:       subtitle,
+		ExternalRating: 0,
+		UploadDate:     uploadDate,
+		Characters:     splitComicInfoList(info.Characters),
+		Tags:           splitComicInfoList(info.Tags),
+		Artists:        splitComicInfoList(info.Writer, info.Penciller),
+		Groups:         splitComicInfoList(info.Teams),
+		Languages:      comicInfoLanguage(info.LanguageISO),
+		Pages:          pages,
+	}
+}
+
+// Inverse of ToImportMetadata, for doujins exported to other readers.
+// Languages without a known ISO code are left out.
+func ComicInfoFromMetadata(doujinMeta DoujinImportMetadata) ComicInfo {
+	info := ComicInfo{
+		Title:      doujinMeta.Title,
+		Series:     doujinMeta.Subtitle,
+		Writer:     strings.Join(doujinMeta.Artists, ", "),
+		Tags:       strings.Join(doujinMeta.Tags, ", "),
+		Characters: strings.Join(doujinMeta.Characters, ", "),
+		Teams:      strings.Join(doujinMeta.Groups, ", "),
+		PageCount:  doujinMeta.Pages,
+		Pages:      []ComicInfoPage{},
+	}
+
+	for _, language := range doujinMeta.Languages {
+		for code, name := range isoLanguageNames {
+			if name == language {
+				info.LanguageISO = code
+				break
+			}
+		}
+		if info.LanguageISO != "" {
+			break
+		}
+	}
+
+	if !doujinMeta.UploadDate.IsZero() {
+		info.Year = doujinMeta.UploadDate.Year()
+		info.Month = int(doujinMeta.UploadDate.Month())
+		info.Day = doujinMeta.UploadDate.Day()
+	}
+
+	for i := range doujinMeta.Pages {
+		info.Pages = append(info.Pages, ComicInfoPage{Image: i})
+	}
+
+	return info
+}
+
+func DecodeComicInfo(r io.Reader) (DoujinImportMetadata, error) {
+	var info ComicInfo
+	err := xml.NewDecoder(r).Decode(&info)
+	if err != nil {
+		return DoujinImportMetadata{}, err
+	}
+
+	return info.ToImportMetadata(), nil
+}
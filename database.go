@@ -10,13 +10,11 @@ import (
 	"fmt"
 	"log"
 	"math"
-	"os"
 	"path"
-	"path/filepath"
 	"slices"
-	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -61,6 +59,12 @@ func hashToken(token string, salt string) string {
 	return base64encode(bytes[:])
 }
 
+// Upload dates are stored in UTC, so they sort in chronological order as
+// text.
+func formatUploadDate(uploadDate time.Time) string {
+	return uploadDate.UTC().Format(time.RFC3339)
+}
+
 func removeExtension(fileName string) string {
 	if ext := path.Ext(fileName); ext != "" {
 		return fileName[:len(fileName)-len(ext)]
@@ -227,24 +231,46 @@ const (
 	DatabaseErrorUnauthorized
 	DatabaseErrorInvalidPageSize
 	DatabaseErrorRegisteringDisabled
+	DatabaseErrorInvalidDistance
+	DatabaseErrorUnavailableDoujin
+	DatabaseErrorInvalidThumbnailSize
+	DatabaseErrorInvalidResizeOptions
+	DatabaseErrorNotInSeries
+	DatabaseErrorNoAdjacentChapter
+	DatabaseErrorInvalidUpload
+	DatabaseErrorUploadTooLarge
+	DatabaseErrorInvalidDoujin
+	DatabaseErrorInvalidSearchQuery
+	DatabaseErrorInvalidSort
 
 	DatabaseErrorCount
 )
 
 var databaseErrorMessages = []string{
-	DatabaseErrorInvalidMetadata:     "Invalid database metadata",
-	DatabaseErrorInvalidSchema:       "Invalid database schema",
-	DatabaseErrorExistentUser:        "User already exists in database",
-	DatabaseErrorInexistentUser:      "User does not exist in database",
-	DatabaseErrorInvalidPassword:     "Invalid password",
-	DatabaseErrorDisallowedUsername:  "Disallowed username",
-	DatabaseErrorDisallowedPassword:  "Disallowed password",
-	DatabaseErrorInvalidToken:        "Invalid token",
-	DatabaseErrorInvalidPageNumber:   "Invalid page number",
-	DatabaseErrorInvalidId:           "Invalid ID",
-	DatabaseErrorUnauthorized:        "Unauthorized",
-	DatabaseErrorInvalidPageSize:     "Invalid page size",
-	DatabaseErrorRegisteringDisabled: "User registering is disabled",
+	DatabaseErrorInvalidMetadata:      "Invalid database metadata",
+	DatabaseErrorInvalidSchema:        "Invalid database schema",
+	DatabaseErrorExistentUser:         "User already exists in database",
+	DatabaseErrorInexistentUser:       "User does not exist in database",
+	DatabaseErrorInvalidPassword:      "Invalid password",
+	DatabaseErrorDisallowedUsername:   "Disallowed username",
+	DatabaseErrorDisallowedPassword:   "Disallowed password",
+	DatabaseErrorInvalidToken:         "Invalid token",
+	DatabaseErrorInvalidPageNumber:    "Invalid page number",
+	DatabaseErrorInvalidId:            "Invalid ID",
+	DatabaseErrorUnauthorized:         "Unauthorized",
+	DatabaseErrorInvalidPageSize:      "Invalid page size",
+	DatabaseErrorRegisteringDisabled:  "User registering is disabled",
+	DatabaseErrorInvalidDistance:      "Invalid distance",
+	DatabaseErrorUnavailableDoujin:    "Doujin unavailable",
+	DatabaseErrorInvalidThumbnailSize: "Invalid thumbnail size",
+	DatabaseErrorInvalidResizeOptions: "Invalid resize options",
+	DatabaseErrorNotInSeries:          "Doujin is not part of a series",
+	DatabaseErrorNoAdjacentChapter:    "No chapter in that direction",
+	DatabaseErrorInvalidUpload:        "Invalid upload",
+	DatabaseErrorUploadTooLarge:       "Upload too large",
+	DatabaseErrorInvalidDoujin:        "Invalid doujin",
+	DatabaseErrorInvalidSearchQuery:   "Invalid search query",
+	DatabaseErrorInvalidSort:          "Invalid sort order",
 }
 
 func init() {
@@ -271,20 +297,40 @@ type DoujinImportMetadata struct {
 	Groups         []string  `json:"group"`
 	Languages      []string  `json:"language"`
 	Pages          int       `json:"pages"`
+
+	// Optional. Names of the page files, in page order.
+	PageFiles []string `json:"page_files"`
+}
+
+type DoujinPage struct {
+	Number   int    `json:"number"`
+	Id       int    `json:"id"`
+	Width    int    `json:"width"`
+	Height   int    `json:"height"`
+	Size     int64  `json:"size"`
+	MimeType string `json:"mime_type"`
+
+	// Name of the page's file when it was imported, relative to the doujin's
+	// folder or archive. Empty for pages imported before names were stored.
+	OriginalName string `json:"original_name"`
 }
 
 type Doujin struct {
-	Id             int      `json:"id"`
-	Title          string   `json:"title"`
-	Subtitle       string   `json:"subtitle"`
-	UploadDate     string   `json:"upload_date"`
-	ExternalRating int      `json:"external_rating"`
-	Tags           []string `json:"tags"`
-	Characters     []string `json:"characters"`
-	Artists        []string `json:"artists"`
-	Groups         []string `json:"groups"`
-	Languages      []string `json:"languages"`
-	Pages          [][]int  `json:"pages"`
+	Id             int          `json:"id"`
+	Title          string       `json:"title"`
+	Subtitle       string       `json:"subtitle"`
+	UploadDate     string       `json:"upload_date"`
+	ExternalRating int          `json:"external_rating"`
+	Tags           []string     `json:"tags"`
+	Characters     []string     `json:"characters"`
+	Artists        []string     `json:"artists"`
+	Groups         []string     `json:"groups"`
+	Languages      []string     `json:"languages"`
+	Pages          []DoujinPage `json:"pages"`
+
+	// Both are 0 for doujins that aren't chapters of a series
+	SeriesId      int `json:"series_id"`
+	ChapterNumber int `json:"chapter_number"`
 }
 
 type SearchResult struct {
@@ -301,6 +347,13 @@ type TagSet struct {
 type Database struct {
 	db           *sql.DB
 	serverConfig ServerConfig
+	resizeCache  *DiskCache
+
+	// Held while files are added to or removed from the library.
+	// libraryReferences counts the imports using each file that aren't
+	// stored yet, so they aren't removed.
+	libraryMutex      sync.Mutex
+	libraryReferences map[string]int
 }
 
 func NewDatabase(serverConfig ServerConfig) (*Database, error) {
@@ -319,7 +372,7 @@ func NewDatabase(serverConfig ServerConfig) (*Database, error) {
 		return schemaVersion, nil
 	}
 
-	db, err := sql.Open("sqlite3", serverConfig.DatabasePath)
+	db, err := sql.Open("sqlite3", serverConfig.DatabasePath+"?_busy_timeout=5000&_txlock=immediate&_foreign_keys=on")
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -331,12 +384,18 @@ func NewDatabase(serverConfig ServerConfig) (*Database, error) {
 		}
 	}()
 
-	schemaVersion, err := isDatabaseInitialized(db)
+	// mattn/go-sqlite3 only has FTS5 when built with the sqlite_fts5 tag
+	var hasFts5 bool
+	err = db.QueryRow(`SELECT sqlite_compileoption_used('ENABLE_FTS5')`).Scan(&hasFts5)
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = db.Exec("PRAGMA foreign_keys = ON")
+	if !hasFts5 {
+		return nil, fmt.Errorf("SQLite was built without FTS5; build hv with `-tags sqlite_fts5`")
+	}
+
+	schemaVersion, err := isDatabaseInitialized(db)
 	if err != nil {
 		return nil, err
 	}
@@ -399,12 +458,23 @@ func NewDatabase(serverConfig ServerConfig) (*Database, error) {
 		if err != nil {
 			return nil, err
 		}
-	} else if schemaVersion != "v1" {
-		return nil, DatabaseErrorInvalidSchema
+
+		schemaVersion = "v1"
+	}
+
+	err = migrateSchema(db, schemaVersion)
+	if err != nil {
+		return nil, err
 	}
 
 	errored = false
-	return &Database{db, serverConfig}, nil
+	resizeCache := NewDiskCache(serverConfig.ResizeCachePath, int64(serverConfig.ResizeCacheMaxSizeMB)*1024*1024)
+	return &Database{
+		db:                db,
+		serverConfig:      serverConfig,
+		resizeCache:       resizeCache,
+		libraryReferences: map[string]int{},
+	}, nil
 }
 
 func (db *Database) authenticateUser(username string, token string) (int, error) {
@@ -435,18 +505,119 @@ func (db *Database) authenticateUser(username string, token string) (int, error)
 	return userId, nil
 }
 
-func (db *Database) ImportDoujin(folderPath string) error {
-	filePath := path.Join(folderPath, "metadata.json")
-	file, err := os.Open(filePath)
+func (db *Database) authenticateAdmin(username string, token string) (int, error) {
+	userId, err := db.authenticateUser(username, token)
+	if err != nil {
+		return 0, err
+	}
+
+	var actualUsername string
+	err = db.db.QueryRow(`SELECT username FROM Users WHERE id = ?`, userId).Scan(&actualUsername)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, adminUsername := range db.serverConfig.AdminUsers {
+		if strings.EqualFold(adminUsername, actualUsername) {
+			return userId, nil
+		}
+	}
+
+	return 0, DatabaseErrorUnauthorized
+}
+
+// Computes everything stored for the pages of a doujin that was already
+// scanned, and moves them to the managed library if it's enabled. Returns the
+// doujin's content hash and the files used in the library, even if it fails,
+// which must be released with releaseLibraryFiles.
+func (db *Database) prepareImportPages(source *doujinSource, pages []importPage) (string, pendingLibraryFiles, error) {
+	pageHashes := []string{}
+	libraryFiles := pendingLibraryFiles{}
+	for i, page := range pages {
+		contentHash, info, err := inspectSourceFile(source.fsys, page.Name)
+		if err != nil {
+			return "", libraryFiles, fmt.Errorf("Failed to read page `%s`: %w", page.File, err)
+		}
+
+		pages[i].ContentHash = contentHash
+		pages[i].Info = info
+		pageHashes = append(pageHashes, contentHash)
+	}
+
+	if db.isLibraryEnabled() {
+		for i, page := range pages {
+			libraryPage, created, err := db.storePageInLibrary(source, page)
+			if err != nil {
+				return "", libraryFiles, fmt.Errorf("Failed to store page `%s` in the library: %w", page.File, err)
+			}
+
+			libraryFiles.used = append(libraryFiles.used, libraryPage.Path)
+			if created {
+				libraryFiles.created = append(libraryFiles.created, libraryPage.Path)
+			}
+
+			pages[i].File = libraryPage
+		}
+	}
+
+	// Pages that can't be decoded simply don't get a perceptual hash
+	for _, pageNumber := range perceptualSamplePages(len(pages)) {
+		page := &pages[pageNumber-1]
+
+		file, err := source.fsys.Open(page.Name)
+		if err != nil {
+			return "", libraryFiles, fmt.Errorf("Failed to read page `%s`: %w", page.File, err)
+		}
+
+		perceptualHash, err := perceptualHashReader(file)
+		file.Close()
+		if err == nil {
+			page.PerceptualHash = sql.NullInt64{Int64: int64(perceptualHash), Valid: true}
+		}
+	}
+
+	return doujinContentHash(pageHashes), libraryFiles, nil
+}
+
+func insertDoujinPages(tx *sql.Tx, doujinId int64, pages []importPage) error {
+	for _, page := range pages {
+		_, err := tx.Exec(
+			`INSERT INTO DoujinPages (
+				doujin_id, page_path, archive_entry, page_number, content_hash, perceptual_hash,
+				width, height, size, mime_type, original_name
+			 ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			doujinId, page.File.Path, page.File.ArchiveEntry, page.Number, page.ContentHash, page.PerceptualHash,
+			page.Info.Width, page.Info.Height, page.Info.Size, page.Info.MimeType, page.Name,
+		)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Imports the doujin in the folder or archive at doujinPath.
+func (db *Database) ImportDoujin(doujinPath string, options ImportOptions) (int, error) {
+	source, err := openDoujinSource(doujinPath)
 	if err != nil {
-		return fmt.Errorf("Failed to open file `%s`: %w", filePath, err)
+		return 0, err
 	}
-	defer file.Close()
+	defer source.Close()
 
-	var doujinMeta DoujinImportMetadata
-	err = json.NewDecoder(file).Decode(&doujinMeta)
+	doujin, err := scanDoujin(source, options)
 	if err != nil {
-		return fmt.Errorf("Failed to decode JSON file `%s`: %w", filePath, err)
+		return 0, err
+	}
+	doujinMeta := doujin.Metadata
+
+	contentHash, libraryFiles, err := db.prepareImportPages(source, doujin.Pages)
+	committed := false
+	defer func() {
+		db.releaseLibraryFiles(libraryFiles, committed)
+	}()
+	if err != nil {
+		return 0, err
 	}
 
 	tags := jsonEncode(doujinMeta.Tags)
@@ -454,80 +625,83 @@ func (db *Database) ImportDoujin(folderPath string) error {
 	artists := jsonEncode(doujinMeta.Artists)
 	groups := jsonEncode(doujinMeta.Groups)
 	languages := jsonEncode(doujinMeta.Languages)
-	uploadDate := doujinMeta.UploadDate.Format(time.RFC3339)
+	uploadDate := formatUploadDate(doujinMeta.UploadDate)
 
 	tx, err := db.db.Begin()
 	if err != nil {
-		return err
+		return 0, err
 	}
 	defer tx.Rollback()
 
+	if db.serverConfig.DuplicatePolicy != DuplicatePolicyImport {
+		duplicateId, err := findDoujinByContentHash(tx, contentHash)
+		if err != nil {
+			return 0, err
+		}
+
+		if duplicateId != 0 {
+			if db.serverConfig.DuplicatePolicy == DuplicatePolicySkip {
+				return 0, DuplicateDoujinError{duplicateId}
+			}
+			log.Printf("WARNING: `%s`: %v\n", doujinPath, DuplicateDoujinError{duplicateId})
+		}
+	}
+
+	var seriesId sql.NullInt64
+	if options.SeriesId != 0 {
+		seriesId = sql.NullInt64{Int64: int64(options.SeriesId), Valid: true}
+	}
+
 	result, err := tx.Exec(
-		`INSERT INTO Doujins (title, subtitle, upload_date, external_rating, tags, characters, artists, groups, languages, pages)
-		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		`INSERT INTO Doujins (
+			title, subtitle, upload_date, external_rating, tags, characters, artists, groups, languages, pages, content_hash,
+			series_id, chapter_number
+		 ) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
 		doujinMeta.Title, doujinMeta.Subtitle, uploadDate, doujinMeta.ExternalRating,
-		tags, characters, artists, groups, languages, doujinMeta.Pages,
+		tags, characters, artists, groups, languages, doujinMeta.Pages, contentHash,
+		seriesId, options.ChapterNumber,
 	)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	doujinId, err := result.LastInsertId()
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	entries, err := os.ReadDir(folderPath)
+	err = insertDoujinPages(tx, doujinId, doujin.Pages)
 	if err != nil {
-		return fmt.Errorf("Failed to read directory `%s`: %w", folderPath, err)
+		return 0, err
 	}
 
-	log.Printf("Importing doujin in folder `%s`\n", folderPath)
-
-	importedPages := []int{}
-	for _, e := range entries {
-		pageNumber := pageNameToPageNumber(e.Name())
-		if e.IsDir() || pageNumber == -1 {
-			continue
-		}
-
-		pagePath, err := filepath.Abs(path.Join(folderPath, e.Name()))
+	// Sources are looked up in the import log by the directory watcher, so
+	// doujins imported by other means aren't imported again
+	if !options.Temporary {
+		signature, err := sourceSignature(source.absolutePath)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
-		_, err = tx.Exec(
-			"INSERT INTO DoujinPages (doujin_id, page_path, page_number) VALUES (?, ?, ?)",
-			doujinId, pagePath, pageNumber,
-		)
+		err = writeImportLogEntry(tx, ImportLogEntry{
+			SourcePath: source.absolutePath,
+			Signature:  signature,
+			DoujinId:   int(doujinId),
+			Error:      "",
+			Date:       time.Now(),
+		})
 		if err != nil {
-			return err
-		}
-
-		importedPages = append(importedPages, pageNumber)
-	}
-
-	if len(importedPages) < doujinMeta.Pages {
-		return fmt.Errorf("Some pages are missing in the folder (found %d out of %d)", len(importedPages), doujinMeta.Pages)
-	}
-
-	if len(importedPages) > doujinMeta.Pages {
-		return fmt.Errorf("The folder contains to many pages (found %d out of %d)", len(importedPages), doujinMeta.Pages)
-	}
-
-	sort.Ints(importedPages)
-	for i, pageNumber := range importedPages {
-		if i+1 != pageNumber {
-			return fmt.Errorf("The pages in the folder are not sequential (expected page %d, found %d)", i+1, pageNumber)
+			return 0, err
 		}
 	}
 
 	err = tx.Commit()
 	if err != nil {
-		return err
+		return 0, err
 	}
+	committed = true
 
-	return nil
+	return int(doujinId), nil
 }
 
 func (db *Database) RegisterUser(username string, password string) error {
@@ -632,9 +806,9 @@ func escapeSqlLike(s string) string {
 }
 
 func buildSearchQuery(
-	query string,
-	tags []string,
-	antiTags []string,
+	query searchNode,
+	filters SearchFilters,
+	sort SearchSort,
 	pageSize int,
 	pageNumber int,
 	count bool,
@@ -642,50 +816,73 @@ func buildSearchQuery(
 	var (
 		queryBuilder    strings.Builder
 		queryParameters []any
-		sqlLikeQuery    = "%" + escapeSqlLike(query) + "%"
+		rankQuery       = searchRankQuery(query)
 	)
 
+	sort = sort.resolve(rankQuery != "")
+
 	// Select
 	if count {
 		queryBuilder.WriteString("SELECT COUNT(*)")
 	} else {
 		queryBuilder.WriteString(
-			"SELECT id, title, subtitle, upload_date, external_rating, tags, characters, artists, groups, languages")
+			`SELECT Doujins.id, Doujins.title, Doujins.subtitle, Doujins.upload_date, Doujins.external_rating,
+				Doujins.tags, Doujins.characters, Doujins.artists, Doujins.groups, Doujins.languages,
+				COALESCE(Doujins.series_id, 0), Doujins.chapter_number`)
 	}
 
-	// Basic search
 	queryBuilder.WriteString(`
 		FROM Doujins
-		WHERE (title LIKE ? ESCAPE '\' OR subtitle LIKE ? ESCAPE '\')
 	`)
-	queryParameters = append(queryParameters, sqlLikeQuery, sqlLikeQuery)
 
-	// Tags
-	for _, tag := range tags {
+	// Ranking, by how well doujins match the text of the query
+	if !count && sort.By == "relevance" {
 		queryBuilder.WriteString(`
-			AND EXISTS (
-				SELECT 1 FROM json_each(Doujins.tags) AS jt
-				WHERE jt.value = ?
-			)
+			LEFT JOIN (
+				SELECT rowid, ` + searchRankFunction + ` AS rank
+				FROM DoujinsSearch
+				WHERE DoujinsSearch MATCH ?
+			) AS SearchRank ON SearchRank.rowid = Doujins.id
 		`)
-		queryParameters = append(queryParameters, tag)
+		queryParameters = append(queryParameters, rankQuery)
 	}
 
-	// Anti-tags
-	for _, tag := range antiTags {
-		queryBuilder.WriteString(`
-			AND NOT EXISTS (
-				SELECT 1 FROM json_each(Doujins.tags) AS jt
-				WHERE jt.value = ?
-			)
-		`)
-		queryParameters = append(queryParameters, tag)
+	// Basic search
+	queryBuilder.WriteString(`
+		WHERE Doujins.unavailable = 0
+	`)
+	if query != nil {
+		queryBuilder.WriteString(" AND ")
+		queryParameters = query.writeSql(&queryBuilder, queryParameters)
+	}
+
+	// Filters
+	for _, filter := range filters.columnFilters() {
+		for _, value := range filter.include {
+			queryBuilder.WriteString(`
+				AND EXISTS (
+					SELECT 1 FROM json_each(Doujins.` + filter.column + `) AS jt
+					WHERE jt.value = ?
+				)
+			`)
+			queryParameters = append(queryParameters, value)
+		}
+
+		for _, value := range filter.exclude {
+			queryBuilder.WriteString(`
+				AND NOT EXISTS (
+					SELECT 1 FROM json_each(Doujins.` + filter.column + `) AS jt
+					WHERE jt.value = ?
+				)
+			`)
+			queryParameters = append(queryParameters, value)
+		}
 	}
 
-	// Pagination
+	// Sorting and pagination
 	if !count {
+		queryBuilder.WriteString(sort.orderBy())
 		queryBuilder.WriteString(`
-			ORDER BY upload_date DESC
 			LIMIT ? OFFSET ?
 		`)
 		queryParameters = append(queryParameters, pageSize, pageSize*(pageNumber-1))
@@ -696,7 +893,7 @@ func buildSearchQuery(
 
 func (db *Database) SearchDoujins(
 	username string, token string,
-	query string, tags []string, antiTags []string, pageSize int, pageNumber int,
+	query string, filters SearchFilters, sort SearchSort, pageSize int, pageNumber int,
 ) (SearchResult, error) {
 	_, err := db.authenticateUser(username, token)
 	if err != nil {
@@ -711,9 +908,18 @@ func (db *Database) SearchDoujins(
 		return SearchResult{}, DatabaseErrorInvalidPageNumber
 	}
 
+	if !sort.isValid() {
+		return SearchResult{}, DatabaseErrorInvalidSort
+	}
+
+	parsedQuery, err := ParseSearchQuery(query)
+	if err != nil {
+		return SearchResult{}, err
+	}
+
 	// Count query
 	var resultsCount int
-	countQuery, countQueryParameters := buildSearchQuery(query, tags, antiTags, pageSize, pageNumber, true)
+	countQuery, countQueryParameters := buildSearchQuery(parsedQuery, filters, sort, pageSize, pageNumber, true)
 	err = db.db.QueryRow(countQuery, countQueryParameters...).Scan(&resultsCount)
 	if err != nil {
 		return SearchResult{}, err
@@ -729,7 +935,7 @@ func (db *Database) SearchDoujins(
 	}
 
 	// Search query
-	searchQuery, searchQueryParameters := buildSearchQuery(query, tags, antiTags, pageSize, pageNumber, false)
+	searchQuery, searchQueryParameters := buildSearchQuery(parsedQuery, filters, sort, pageSize, pageNumber, false)
 	rows, err := db.db.Query(searchQuery, searchQueryParameters...)
 	if err != nil {
 		return SearchResult{}, err
@@ -748,6 +954,8 @@ func (db *Database) SearchDoujins(
 		var artistsJson string
 		var groupsJson string
 		var languagesJson string
+		var seriesId int
+		var chapterNumber int
 
 		err = rows.Scan(
 			&id,
@@ -760,6 +968,8 @@ func (db *Database) SearchDoujins(
 			&artistsJson,
 			&groupsJson,
 			&languagesJson,
+			&seriesId,
+			&chapterNumber,
 		)
 		if err != nil {
 			return SearchResult{}, err
@@ -795,13 +1005,16 @@ func (db *Database) SearchDoujins(
 			return SearchResult{}, fmt.Errorf("Got invalid JSON from database")
 		}
 
-		capePageId := 0
-		err = db.db.QueryRow(`SELECT id FROM DoujinPages WHERE doujin_id = ? AND page_number = 1`, id).Scan(&capePageId)
+		capePage := DoujinPage{Number: 1}
+		err = db.db.QueryRow(
+			`SELECT id, width, height, size, mime_type, original_name FROM DoujinPages WHERE doujin_id = ? AND page_number = 1`,
+			id,
+		).Scan(&capePage.Id, &capePage.Width, &capePage.Height, &capePage.Size, &capePage.MimeType, &capePage.OriginalName)
 		if err != sql.ErrNoRows && err != nil {
 			return SearchResult{}, err
 		}
 
-		pages := [][]int{{1, capePageId}}
+		pages := []DoujinPage{capePage}
 
 		doujins = append(doujins, Doujin{
 			Id:             id,
@@ -815,6 +1028,8 @@ func (db *Database) SearchDoujins(
 			Groups:         groups,
 			Languages:      languages,
 			Pages:          pages,
+			SeriesId:       seriesId,
+			ChapterNumber:  chapterNumber,
 		})
 	}
 
@@ -840,9 +1055,13 @@ func (db *Database) GetDoujinMetadata(username string, token string, id int) (Do
 	var resultArtistsJson string
 	var resultGroupsJson string
 	var resultLanguagesJson string
+	var resultUnavailable bool
+	var resultSeriesId int
+	var resultChapterNumber int
 
 	err = db.db.QueryRow(
-		`SELECT id, title, subtitle, upload_date, external_rating, tags, characters, artists, groups, languages
+		`SELECT id, title, subtitle, upload_date, external_rating, tags, characters, artists, groups, languages, unavailable,
+			COALESCE(series_id, 0), chapter_number
 		 FROM Doujins
 		 WHERE id = ?`,
 		id,
@@ -850,7 +1069,7 @@ func (db *Database) GetDoujinMetadata(username string, token string, id int) (Do
 		&resultId, &resultTitle, &resultSubtitle,
 		&resultUploadDate, &resultExternalRating, &resultTagsJson,
 		&resultCharactersJson, &resultArtistsJson, &resultGroupsJson,
-		&resultLanguagesJson,
+		&resultLanguagesJson, &resultUnavailable, &resultSeriesId, &resultChapterNumber,
 	)
 
 	if err == sql.ErrNoRows {
@@ -861,6 +1080,10 @@ func (db *Database) GetDoujinMetadata(username string, token string, id int) (Do
 		return Doujin{}, err
 	}
 
+	if resultUnavailable {
+		return Doujin{}, DatabaseErrorUnavailableDoujin
+	}
+
 	var resultTags []string
 	err = json.Unmarshal([]byte(resultTagsJson), &resultTags)
 	if err != nil {
@@ -891,24 +1114,26 @@ func (db *Database) GetDoujinMetadata(username string, token string, id int) (Do
 		return Doujin{}, fmt.Errorf("Got invalid JSON from database")
 	}
 
-	rows, err := db.db.Query(`SELECT id, page_number FROM DoujinPages WHERE doujin_id = ?`, id)
+	rows, err := db.db.Query(
+		`SELECT page_number, id, width, height, size, mime_type, original_name FROM DoujinPages WHERE doujin_id = ? ORDER BY page_number`,
+		id,
+	)
 	if err != nil {
 		return Doujin{}, err
 	}
 	defer rows.Close()
 
-	pages := [][]int{}
+	pages := []DoujinPage{}
 
 	for rows.Next() {
-		var pageId int
-		var pageNumber int
+		var page DoujinPage
 
-		err = rows.Scan(&pageId, &pageNumber)
+		err = rows.Scan(&page.Number, &page.Id, &page.Width, &page.Height, &page.Size, &page.MimeType, &page.OriginalName)
 		if err != nil {
 			return Doujin{}, err
 		}
 
-		pages = append(pages, []int{pageNumber, pageId})
+		pages = append(pages, page)
 	}
 
 	return Doujin{
@@ -923,6 +1148,8 @@ func (db *Database) GetDoujinMetadata(username string, token string, id int) (Do
 		Groups:         resultGroups,
 		Languages:      resultLanguages,
 		Pages:          pages,
+		SeriesId:       resultSeriesId,
+		ChapterNumber:  resultChapterNumber,
 	}, nil
 }
 
@@ -935,6 +1162,7 @@ func (db *Database) GetAllTags(username string, token string) ([]string, error)
 	rows, err := db.db.Query(`
 		SELECT DISTINCT jt.value AS tag
 		FROM Doujins, json_each(Doujins.tags) as jt
+		WHERE Doujins.unavailable = 0
 		ORDER BY tag
 	`)
 	if err != nil {
@@ -957,24 +1185,34 @@ func (db *Database) GetAllTags(username string, token string) ([]string, error)
 	return tags, nil
 }
 
-func (db *Database) GetPageFilePath(username string, token string, pageId int) (string, error) {
+func (db *Database) GetPageFile(username string, token string, pageId int) (PageFile, error) {
 	_, err := db.authenticateUser(username, token)
 	if err != nil {
-		return "", err
+		return PageFile{}, err
 	}
 
-	var pagePath string
-	err = db.db.QueryRow("SELECT page_path FROM DoujinPages WHERE id = ?", pageId).Scan(&pagePath)
+	var pageFile PageFile
+	var unavailable bool
+	err = db.db.QueryRow(
+		`SELECT DoujinPages.page_path, DoujinPages.archive_entry, Doujins.unavailable
+		 FROM DoujinPages JOIN Doujins ON Doujins.id = DoujinPages.doujin_id
+		 WHERE DoujinPages.id = ?`,
+		pageId,
+	).Scan(&pageFile.Path, &pageFile.ArchiveEntry, &unavailable)
 
 	if err == sql.ErrNoRows {
-		return "", DatabaseErrorInvalidId
+		return PageFile{}, DatabaseErrorInvalidId
 	}
 
 	if err != nil {
-		return "", err
+		return PageFile{}, err
+	}
+
+	if unavailable {
+		return PageFile{}, DatabaseErrorUnavailableDoujin
 	}
 
-	return pagePath, nil
+	return pageFile, nil
 }
 
 func (db *Database) CreateTagSet(username string, token string, tags []string, antiTags []string) (int, error) {
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"slices"
+)
+
+type DeleteDoujinsResult struct {
+	DeletedDoujins int
+	DeletedFiles   []string
+	FileErrors     []error
+}
+
+// Deletes the doujins with the given IDs along with their pages. If
+// deleteFiles is true, page files in the managed library that are no longer
+// used by any doujin are deleted too. Files outside the library are never
+// deleted.
+func (db *Database) DeleteDoujins(doujinIds []int, deleteFiles bool) (DeleteDoujinsResult, error) {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return DeleteDoujinsResult{}, err
+	}
+	defer tx.Rollback()
+
+	// Each doujin is only deleted once, even if its ID is repeated
+	uniqueIds := []int{}
+	for _, doujinId := range doujinIds {
+		if !slices.Contains(uniqueIds, doujinId) {
+			uniqueIds = append(uniqueIds, doujinId)
+		}
+	}
+
+	pagePaths := []string{}
+	for _, doujinId := range uniqueIds {
+		err = tx.QueryRow(`SELECT 1 FROM Doujins WHERE id = ?`, doujinId).Scan(new(int))
+		if err == sql.ErrNoRows {
+			return DeleteDoujinsResult{}, fmt.Errorf("Doujin %d: %w", doujinId, DatabaseErrorInvalidId)
+		}
+
+		if err != nil {
+			return DeleteDoujinsResult{}, err
+		}
+
+		rows, err := tx.Query(`SELECT DISTINCT page_path FROM DoujinPages WHERE doujin_id = ?`, doujinId)
+		if err != nil {
+			return DeleteDoujinsResult{}, err
+		}
+
+		for rows.Next() {
+			var pagePath string
+			err = rows.Scan(&pagePath)
+			if err != nil {
+				rows.Close()
+				return DeleteDoujinsResult{}, err
+			}
+			pagePaths = append(pagePaths, pagePath)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return DeleteDoujinsResult{}, err
+		}
+
+		// Pages are deleted by the foreign key's ON DELETE CASCADE
+		_, err = tx.Exec(`DELETE FROM Doujins WHERE id = ?`, doujinId)
+		if err != nil {
+			return DeleteDoujinsResult{}, err
+		}
+
+		_, err = tx.Exec(`UPDATE ImportLog SET doujin_id = NULL WHERE doujin_id = ?`, doujinId)
+		if err != nil {
+			return DeleteDoujinsResult{}, err
+		}
+	}
+
+	err = deleteEmptySeries(tx)
+	if err != nil {
+		return DeleteDoujinsResult{}, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return DeleteDoujinsResult{}, err
+	}
+
+	result := DeleteDoujinsResult{
+		DeletedDoujins: len(uniqueIds),
+		DeletedFiles:   []string{},
+		FileErrors:     []error{},
+	}
+
+	if !deleteFiles {
+		return result, nil
+	}
+
+	db.libraryMutex.Lock()
+	defer db.libraryMutex.Unlock()
+
+	for _, pagePath := range pagePaths {
+		if !db.isManagedPath(pagePath) {
+			continue
+		}
+
+		removed, err := db.removeUnusedLibraryFile(pagePath)
+		if err != nil {
+			result.FileErrors = append(result.FileErrors, err)
+			continue
+		}
+
+		if removed {
+			result.DeletedFiles = append(result.DeletedFiles, pagePath)
+		}
+	}
+
+	return result, nil
+}
+
+func (db *Database) DeleteDoujin(username string, token string, doujinId int, deleteFiles bool) error {
+	_, err := db.authenticateAdmin(username, token)
+	if err != nil {
+		return err
+	}
+
+	result, err := db.DeleteDoujins([]int{doujinId}, deleteFiles)
+	if errors.Is(err, DatabaseErrorInvalidId) {
+		return DatabaseErrorInvalidId
+	}
+
+	if err != nil {
+		return err
+	}
+
+	// The doujin is already gone at this point, so failing to delete its
+	// files isn't an error for the client.
+	for _, err := range result.FileErrors {
+		log.Printf("Failed to delete file of doujin %d: %v\n", doujinId, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"container/list"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+type diskCacheEntry struct {
+	name string
+	size int64
+}
+
+// A DiskCache keeps files in a directory, deleting the least recently used
+// ones when their total size goes over maxSize. When a file was last used is
+// kept as its modification time, so it survives restarts.
+type DiskCache struct {
+	root    string
+	maxSize int64
+
+	mutex   sync.Mutex
+	loaded  bool
+	size    int64
+	entries map[string]*list.Element // values are *diskCacheEntry
+	lru     *list.List               // most recently used first
+}
+
+func NewDiskCache(root string, maxSize int64) *DiskCache {
+	return &DiskCache{
+		root:    root,
+		maxSize: maxSize,
+		entries: map[string]*list.Element{},
+		lru:     list.New(),
+	}
+}
+
+func (cache *DiskCache) path(name string) string {
+	return filepath.Join(cache.root, name[:2], name)
+}
+
+// Reads the files already in the cache directory the first time the cache is
+// used. Must be called with the mutex locked.
+func (cache *DiskCache) load() {
+	if cache.loaded {
+		return
+	}
+	cache.loaded = true
+
+	type cachedFile struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+
+	files := []cachedFile{}
+	filepath.WalkDir(cache.root, func(filePath string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return nil
+		}
+
+		files = append(files, cachedFile{entry.Name(), info.Size(), info.ModTime()})
+		return nil
+	})
+
+	slices.SortFunc(files, func(a cachedFile, b cachedFile) int {
+		return b.modTime.Compare(a.modTime)
+	})
+
+	for _, file := range files {
+		cache.entries[file.name] = cache.lru.PushBack(&diskCacheEntry{file.name, file.size})
+		cache.size += file.size
+	}
+
+	cache.evict()
+}
+
+// The most recently used file is never evicted, even if it's bigger than the
+// whole cache, as it's about to be read. Must be called with the mutex locked.
+func (cache *DiskCache) evict() {
+	for cache.size > cache.maxSize && cache.lru.Len() > 1 {
+		entry := cache.lru.Remove(cache.lru.Back()).(*diskCacheEntry)
+		delete(cache.entries, entry.name)
+		cache.size -= entry.size
+
+		err := os.Remove(cache.path(entry.name))
+		if err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to delete cached file `%s`: %v\n", entry.name, err)
+		}
+	}
+}
+
+// Opens the cached file called name, if there's one. The file is opened while
+// the cache is locked, so it can still be read after being evicted.
+func (cache *DiskCache) Get(name string) (*os.File, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.load()
+
+	element, ok := cache.entries[name]
+	if !ok {
+		return nil, false
+	}
+
+	file, err := os.Open(cache.path(name))
+	if err != nil {
+		// Deleted behind the cache's back
+		cache.lru.Remove(element)
+		delete(cache.entries, name)
+		cache.size -= element.Value.(*diskCacheEntry).size
+		return nil, false
+	}
+
+	now := time.Now()
+	os.Chtimes(file.Name(), now, now)
+
+	cache.lru.MoveToFront(element)
+	return file, true
+}
+
+// Stores the file called name, whose content is written by write, in the
+// cache, and opens it like Get.
+func (cache *DiskCache) Put(name string, write func(w io.Writer) error) (*os.File, error) {
+	filePath := cache.path(name)
+
+	// Files are written to a temporary file first so readers never see an
+	// incomplete file.
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	temporaryFile, err := os.CreateTemp(filepath.Dir(filePath), ".tmp-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	err = write(temporaryFile)
+	if err == nil {
+		err = temporaryFile.Chmod(0644)
+	}
+	if err != nil {
+		temporaryFile.Close()
+		return nil, err
+	}
+
+	info, err := temporaryFile.Stat()
+	if err != nil {
+		temporaryFile.Close()
+		return nil, err
+	}
+
+	err = temporaryFile.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.load()
+
+	err = os.Rename(temporaryFile.Name(), filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if element, ok := cache.entries[name]; ok {
+		cache.size -= element.Value.(*diskCacheEntry).size
+		cache.lru.Remove(element)
+	}
+
+	cache.entries[name] = cache.lru.PushFront(&diskCacheEntry{name, info.Size()})
+	cache.size += info.Size()
+	cache.evict()
+
+	return os.Open(filePath)
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Most file systems limit names to 255 bytes
+const MaxDownloadFileNameLength = 200
+
+// A DoujinDownload is a doujin ready to be written as a CBZ archive.
+type DoujinDownload struct {
+	Doujin    Doujin
+	PageFiles []PageFile // in page order
+}
+
+// Returns the doujin with ID doujinId and the files of its pages, to be
+// written with WriteArchive.
+func (db *Database) GetDoujinDownload(username string, token string, doujinId int) (DoujinDownload, error) {
+	doujin, err := db.GetDoujinMetadata(username, token, doujinId)
+	if err != nil {
+		return DoujinDownload{}, err
+	}
+
+	rows, err := db.db.Query(
+		`SELECT page_path, archive_entry FROM DoujinPages WHERE doujin_id = ? ORDER BY page_number`,
+		doujinId,
+	)
+	if err != nil {
+		return DoujinDownload{}, err
+	}
+	defer rows.Close()
+
+	pageFiles := []PageFile{}
+	for rows.Next() {
+		var pageFile PageFile
+		err = rows.Scan(&pageFile.Path, &pageFile.ArchiveEntry)
+		if err != nil {
+			return DoujinDownload{}, err
+		}
+		pageFiles = append(pageFiles, pageFile)
+	}
+
+	if err = rows.Err(); err != nil {
+		return DoujinDownload{}, err
+	}
+
+	return DoujinDownload{doujin, pageFiles}, nil
+}
+
+// Returns the name the archive is downloaded as, which is the doujin's title
+// without the characters that aren't allowed in file names.
+func (download DoujinDownload) FileName() string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`/\:*?"<>|`, r) {
+			return '_'
+		}
+		return r
+	}, download.Doujin.Title)
+	name = strings.Trim(strings.TrimSpace(name), ".")
+
+	for len(name) > MaxDownloadFileNameLength {
+		_, size := utf8.DecodeLastRuneInString(name)
+		name = name[:len(name)-size]
+	}
+	name = strings.TrimSpace(name)
+
+	if name == "" {
+		name = fmt.Sprintf("doujin-%d", download.Doujin.Id)
+	}
+	return name + ".cbz"
+}
+
+// Pages are named after their number, padded so they sort in order by name.
+func (download DoujinDownload) pageNames() []string {
+	digits := len(fmt.Sprint(len(download.PageFiles)))
+
+	names := []string{}
+	for i, pageFile := range download.PageFiles {
+		extension := strings.ToLower(path.Ext(pageFile.Name()))
+		names = append(names, fmt.Sprintf("%0*d%s", digits, i+1, extension))
+	}
+	return names
+}
+
+func (download DoujinDownload) importMetadata(pageNames []string) DoujinImportMetadata {
+	doujin := download.Doujin
+
+	// Dates that can't be parsed are left out instead of failing the download
+	uploadDate, _ := time.Parse(time.RFC3339, doujin.UploadDate)
+
+	return DoujinImportMetadata{
+		Title:          doujin.Title,
+		Subtitle:       doujin.Subtitle,
+		ExternalRating: doujin.ExternalRating,
+		UploadDate:     uploadDate,
+		Characters:     doujin.Characters,
+		Tags:           doujin.Tags,
+		Artists:        doujin.Artists,
+		Groups:         doujin.Groups,
+		Languages:      doujin.Languages,
+		Pages:          len(pageNames),
+		PageFiles:      pageNames,
+	}
+}
+
+// Writes the doujin to w as a CBZ archive with its pages, in order, a
+// metadata.json file and a ComicInfo.xml file, so it can be read by other
+// readers and imported again. The archive is built as it's written, reading
+// one page at a time. If writing fails, the archive is left incomplete.
+func (download DoujinDownload) WriteArchive(w io.Writer) error {
+	archive := zip.NewWriter(w)
+	modified := time.Now()
+
+	pageNames := download.pageNames()
+	for i, pageFile := range download.PageFiles {
+		// Pages are already compressed images
+		entry, err := archive.CreateHeader(&zip.FileHeader{
+			Name:     pageNames[i],
+			Method:   zip.Store,
+			Modified: modified,
+		})
+		if err != nil {
+			return err
+		}
+
+		err = func() error {
+			file, err := pageFile.Open()
+			if err != nil {
+				return err
+			}
+			defer file.Close()
+
+			_, err = io.Copy(entry, file)
+			return err
+		}()
+		if err != nil {
+			return fmt.Errorf("Failed to read page `%s`: %w", pageFile, err)
+		}
+	}
+
+	doujinMeta := download.importMetadata(pageNames)
+
+	entry, err := archive.CreateHeader(&zip.FileHeader{Name: "metadata.json", Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+
+	jsonEncoder := json.NewEncoder(entry)
+	jsonEncoder.SetIndent("", "    ")
+	err = jsonEncoder.Encode(doujinMeta)
+	if err != nil {
+		return err
+	}
+
+	entry, err = archive.CreateHeader(&zip.FileHeader{Name: "ComicInfo.xml", Method: zip.Deflate, Modified: modified})
+	if err != nil {
+		return err
+	}
+
+	_, err = io.WriteString(entry, xml.Header)
+	if err != nil {
+		return err
+	}
+
+	xmlEncoder := xml.NewEncoder(entry)
+	xmlEncoder.Indent("", "  ")
+	err = xmlEncoder.Encode(ComicInfoFromMetadata(doujinMeta))
+	if err != nil {
+		return err
+	}
+
+	return archive.Close()
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+)
+
+const (
+	DuplicatePolicySkip   = "skip"
+	DuplicatePolicyWarn   = "warn"
+	DuplicatePolicyImport = "import"
+)
+
+type DuplicateDoujinError struct {
+	DoujinId int
+}
+
+func (err DuplicateDoujinError) Error() string {
+	return fmt.Sprintf("The doujin has the same pages as doujin %d", err.DoujinId)
+}
+
+func hashReader(r io.Reader) (string, error) {
+	hash := sha256.New()
+	_, err := io.Copy(hash, r)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// The content hash of a doujin identifies its set of pages: two doujins have
+// the same content hash if they have the same pages, regardless of their order
+// or names.
+func doujinContentHash(pageHashes []string) string {
+	pageHashes = slices.Clone(pageHashes)
+	slices.Sort(pageHashes)
+	pageHashes = slices.Compact(pageHashes)
+
+	hash := sha256.Sum256([]byte(strings.Join(pageHashes, "\n")))
+	return hex.EncodeToString(hash[:])
+}
+
+// Returns the ID of a doujin with the given content hash, or 0 if there's
+// none.
+func findDoujinByContentHash(tx *sql.Tx, contentHash string) (int, error) {
+	var doujinId int
+	err := tx.QueryRow(`SELECT id FROM Doujins WHERE content_hash = ? LIMIT 1`, contentHash).Scan(&doujinId)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return doujinId, err
+}
+
+type DoujinListing struct {
+	Id    int    `json:"id"`
+	Title string `json:"title"`
+	Pages int    `json:"pages"`
+}
+
+// Computes the content hashes of pages imported before content hashes
+// existed, and then of their doujins.
+func (db *Database) HashUnhashedPages(progress func(done int, total int)) (BackfillResult, error) {
+	rows, err := db.db.Query(`SELECT id, page_path, archive_entry FROM DoujinPages WHERE content_hash = ''`)
+	if err != nil {
+		return BackfillResult{}, err
+	}
+
+	pageIds := []int{}
+	pageFiles := []PageFile{}
+	for rows.Next() {
+		var pageId int
+		var pageFile PageFile
+		err = rows.Scan(&pageId, &pageFile.Path, &pageFile.ArchiveEntry)
+		if err != nil {
+			rows.Close()
+			return BackfillResult{}, err
+		}
+
+		pageIds = append(pageIds, pageId)
+		pageFiles = append(pageFiles, pageFile)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return BackfillResult{}, err
+	}
+
+	result := BackfillResult{Failures: []BulkImportFailure{}}
+	for i, pageFile := range pageFiles {
+		if progress != nil {
+			progress(i, len(pageFiles))
+		}
+
+		contentHash, err := func() (string, error) {
+			file, err := pageFile.Open()
+			if err != nil {
+				return "", err
+			}
+			defer file.Close()
+
+			return hashReader(file)
+		}()
+		if err != nil {
+			result.Failures = append(result.Failures, BulkImportFailure{pageFile.String(), err.Error()})
+			continue
+		}
+
+		_, err = db.db.Exec(`UPDATE DoujinPages SET content_hash = ? WHERE id = ?`, contentHash, pageIds[i])
+		if err != nil {
+			return result, err
+		}
+		result.Updated++
+	}
+
+	if progress != nil {
+		progress(len(pageFiles), len(pageFiles))
+	}
+
+	// Doujins only get a content hash once all of their pages have one
+	rows, err = db.db.Query(`
+		SELECT Doujins.id, json_group_array(DoujinPages.content_hash)
+		FROM Doujins JOIN DoujinPages ON DoujinPages.doujin_id = Doujins.id
+		WHERE Doujins.content_hash = ''
+		GROUP BY Doujins.id
+		HAVING SUM(DoujinPages.content_hash = '') = 0
+	`)
+	if err != nil {
+		return result, err
+	}
+
+	doujinHashes := map[int]string{}
+	for rows.Next() {
+		var doujinId int
+		var pageHashesJson string
+		err = rows.Scan(&doujinId, &pageHashesJson)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+
+		var pageHashes []string
+		err = json.Unmarshal([]byte(pageHashesJson), &pageHashes)
+		if err != nil {
+			rows.Close()
+			return result, fmt.Errorf("Got invalid JSON from database")
+		}
+
+		doujinHashes[doujinId] = doujinContentHash(pageHashes)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	for doujinId, contentHash := range doujinHashes {
+		_, err = db.db.Exec(`UPDATE Doujins SET content_hash = ? WHERE id = ?`, contentHash, doujinId)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// Returns groups of doujins that have the same pages.
+func (db *Database) FindDuplicateDoujins() ([][]DoujinListing, error) {
+	rows, err := db.db.Query(`
+		SELECT id, title, pages, content_hash FROM Doujins
+		WHERE content_hash IN (
+			SELECT content_hash FROM Doujins
+			WHERE content_hash != ''
+			GROUP BY content_hash
+			HAVING COUNT(*) > 1
+		)
+		ORDER BY content_hash, id
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	groups := [][]DoujinListing{}
+	lastContentHash := ""
+	for rows.Next() {
+		var doujin DoujinListing
+		var contentHash string
+		err = rows.Scan(&doujin.Id, &doujin.Title, &doujin.Pages, &contentHash)
+		if err != nil {
+			return nil, err
+		}
+
+		if contentHash != lastContentHash {
+			groups = append(groups, []DoujinListing{})
+			lastContentHash = contentHash
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], doujin)
+	}
+
+	return groups, rows.Err()
+}
@@ -0,0 +1,330 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type FsckOptions struct {
+	Repair bool
+
+	// When repairing, pages that are missing are looked for under NewRoot,
+	// for when their files were moved there.
+	NewRoot string
+}
+
+type FsckProblem struct {
+	DoujinId    int
+	Description string
+	Repaired    bool
+}
+
+type FsckReport struct {
+	CheckedDoujins int
+	CheckedPages   int
+	Problems       []FsckProblem
+}
+
+func (report FsckReport) Unrepaired() int {
+	unrepaired := 0
+	for _, problem := range report.Problems {
+		if !problem.Repaired {
+			unrepaired++
+		}
+	}
+	return unrepaired
+}
+
+func checkPage(pageFile PageFile, contentHash string) error {
+	err := func() error {
+		file, err := pageFile.Open()
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+
+		return verifyImage(file)
+	}()
+	if err != nil || contentHash == "" {
+		return err
+	}
+
+	// verifyImage doesn't always read the whole file, so it's read again
+	file, err := pageFile.Open()
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	actualHash, err := hashReader(file)
+	if err != nil {
+		return err
+	}
+
+	if actualHash != contentHash {
+		return fmt.Errorf("The content of the file changed since it was imported")
+	}
+
+	return nil
+}
+
+// Looks for a moved page under newRoot: the longest trailing part of the
+// page's path that exists under newRoot and holds the same page wins.
+func findMovedPage(pageFile PageFile, contentHash string, newRoot string) (PageFile, bool) {
+	components := strings.Split(strings.TrimPrefix(filepath.ToSlash(pageFile.Path), "/"), "/")
+	for i := range components {
+		candidate := PageFile{
+			Path:         filepath.Join(newRoot, filepath.Join(components[i:]...)),
+			ArchiveEntry: pageFile.ArchiveEntry,
+		}
+
+		if _, err := os.Stat(candidate.Path); err != nil {
+			continue
+		}
+
+		if checkPage(candidate, contentHash) == nil {
+			return candidate, true
+		}
+	}
+
+	return PageFile{}, false
+}
+
+type fsckPage struct {
+	id          int
+	number      int
+	file        PageFile
+	contentHash string
+}
+
+// Checks that every page of every doujin exists and is a valid image, that
+// doujins have as many pages as their metadata says, and that no rows point
+// to doujins that don't exist. When repairing, orphaned rows are removed,
+// missing pages are looked for under options.NewRoot, and doujins that are
+// still broken are marked as unavailable (and the ones that aren't anymore,
+// as available).
+func (db *Database) Fsck(options FsckOptions, progress func(done int, total int)) (FsckReport, error) {
+	report := FsckReport{Problems: []FsckProblem{}}
+
+	// Orphaned pages
+	rows, err := db.db.Query(`
+		SELECT doujin_id, COUNT(*) FROM DoujinPages
+		WHERE doujin_id NOT IN (SELECT id FROM Doujins)
+		GROUP BY doujin_id
+	`)
+	if err != nil {
+		return report, err
+	}
+
+	for rows.Next() {
+		var problem FsckProblem
+		var pages int
+		err = rows.Scan(&problem.DoujinId, &pages)
+		if err != nil {
+			rows.Close()
+			return report, err
+		}
+
+		problem.Description = fmt.Sprintf("%d pages belong to a doujin that doesn't exist", pages)
+		problem.Repaired = options.Repair
+		report.Problems = append(report.Problems, problem)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return report, err
+	}
+
+	if options.Repair {
+		_, err = db.db.Exec(`DELETE FROM DoujinPages WHERE doujin_id NOT IN (SELECT id FROM Doujins)`)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// Orphaned import log entries
+	rows, err = db.db.Query(`
+		SELECT doujin_id, source_path FROM ImportLog
+		WHERE doujin_id IS NOT NULL AND doujin_id NOT IN (SELECT id FROM Doujins)
+	`)
+	if err != nil {
+		return report, err
+	}
+
+	for rows.Next() {
+		var problem FsckProblem
+		var sourcePath string
+		err = rows.Scan(&problem.DoujinId, &sourcePath)
+		if err != nil {
+			rows.Close()
+			return report, err
+		}
+
+		problem.Description = fmt.Sprintf("The import log entry of `%s` points to a doujin that doesn't exist", sourcePath)
+		problem.Repaired = options.Repair
+		report.Problems = append(report.Problems, problem)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return report, err
+	}
+
+	if options.Repair {
+		_, err = db.db.Exec(`UPDATE ImportLog SET doujin_id = NULL WHERE doujin_id NOT IN (SELECT id FROM Doujins)`)
+		if err != nil {
+			return report, err
+		}
+	}
+
+	// Doujins and their pages
+	var totalPages int
+	err = db.db.QueryRow(`SELECT COUNT(*) FROM DoujinPages WHERE doujin_id IN (SELECT id FROM Doujins)`).Scan(&totalPages)
+	if err != nil {
+		return report, err
+	}
+
+	type fsckDoujin struct {
+		id          int
+		pages       int
+		unavailable bool
+	}
+
+	rows, err = db.db.Query(`SELECT id, pages, unavailable FROM Doujins ORDER BY id`)
+	if err != nil {
+		return report, err
+	}
+
+	doujins := []fsckDoujin{}
+	for rows.Next() {
+		var doujin fsckDoujin
+		err = rows.Scan(&doujin.id, &doujin.pages, &doujin.unavailable)
+		if err != nil {
+			rows.Close()
+			return report, err
+		}
+		doujins = append(doujins, doujin)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return report, err
+	}
+
+	// Pages stored in the same archive are moved together
+	movedPaths := map[string]string{}
+
+	for _, doujin := range doujins {
+		rows, err = db.db.Query(
+			`SELECT id, page_number, page_path, archive_entry, content_hash FROM DoujinPages WHERE doujin_id = ? ORDER BY page_number`,
+			doujin.id,
+		)
+		if err != nil {
+			return report, err
+		}
+
+		pages := []fsckPage{}
+		for rows.Next() {
+			var page fsckPage
+			err = rows.Scan(&page.id, &page.number, &page.file.Path, &page.file.ArchiveEntry, &page.contentHash)
+			if err != nil {
+				rows.Close()
+				return report, err
+			}
+			pages = append(pages, page)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return report, err
+		}
+
+		broken := false
+		if len(pages) != doujin.pages {
+			report.Problems = append(report.Problems, FsckProblem{
+				DoujinId:    doujin.id,
+				Description: fmt.Sprintf("The doujin has %d pages, but its metadata says it has %d", len(pages), doujin.pages),
+			})
+			broken = true
+		}
+
+		for _, page := range pages {
+			if progress != nil {
+				progress(report.CheckedPages, totalPages)
+			}
+			report.CheckedPages++
+
+			err = checkPage(page.file, page.contentHash)
+			if err == nil {
+				continue
+			}
+
+			problem := FsckProblem{
+				DoujinId:    doujin.id,
+				Description: fmt.Sprintf("Page %d (`%s`): %v", page.number, page.file, err),
+			}
+
+			if options.Repair && options.NewRoot != "" {
+				movedPage := page.file
+				found := false
+				if movedPath, ok := movedPaths[page.file.Path]; ok {
+					movedPage.Path = movedPath
+					found = checkPage(movedPage, page.contentHash) == nil
+				}
+				if !found {
+					movedPage, found = findMovedPage(page.file, page.contentHash, options.NewRoot)
+				}
+
+				if found {
+					_, err = db.db.Exec(`UPDATE DoujinPages SET page_path = ? WHERE id = ?`, movedPage.Path, page.id)
+					if err != nil {
+						return report, err
+					}
+
+					movedPaths[page.file.Path] = movedPage.Path
+					problem.Description += fmt.Sprintf("; moved to `%s`", movedPage)
+					problem.Repaired = true
+				}
+			}
+
+			report.Problems = append(report.Problems, problem)
+			if !problem.Repaired {
+				broken = true
+			}
+		}
+
+		if broken == doujin.unavailable {
+			continue
+		}
+
+		if !broken {
+			report.Problems = append(report.Problems, FsckProblem{
+				DoujinId:    doujin.id,
+				Description: "The doujin is marked as unavailable, but has no problems",
+				Repaired:    options.Repair,
+			})
+		}
+
+		if !options.Repair {
+			continue
+		}
+
+		_, err = db.db.Exec(`UPDATE Doujins SET unavailable = ? WHERE id = ?`, broken, doujin.id)
+		if err != nil {
+			return report, err
+		}
+
+		if broken {
+			report.Problems = append(report.Problems, FsckProblem{
+				DoujinId:    doujin.id,
+				Description: "Marked the doujin as unavailable",
+				Repaired:    true,
+			})
+		}
+	}
+	report.CheckedDoujins = len(doujins)
+
+	if progress != nil {
+		progress(report.CheckedPages, totalPages)
+	}
+
+	return report, nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"io"
+	"path"
+	"strings"
+)
+
+// Number of bytes needed by imageTypeFromMagic.
+const ImageMagicLength = 12
+
+// Returns the MIME type of the image file named fileName based on its
+// extension, or an empty string if it's not a supported image type.
+func imageTypeFromExtension(fileName string) string {
+	switch strings.ToLower(path.Ext(fileName)) {
+	case ".gif":
+		return "image/gif"
+	case ".jpg", ".jpeg":
+		return "image/jpeg"
+	case ".png":
+		return "image/png"
+	case ".webp":
+		return "image/webp"
+	default:
+		return ""
+	}
+}
+
+// Returns the MIME type of the image whose first bytes are `header`, or an
+// empty string if it's not a supported image type.
+func imageTypeFromMagic(header []byte) string {
+	switch {
+	case bytes.HasPrefix(header, []byte("\xFF\xD8\xFF")):
+		return "image/jpeg"
+	case bytes.HasPrefix(header, []byte("\x89PNG\r\n\x1A\n")):
+		return "image/png"
+	case bytes.HasPrefix(header, []byte("GIF87a")), bytes.HasPrefix(header, []byte("GIF89a")):
+		return "image/gif"
+	case len(header) >= 12 && bytes.Equal(header[:4], []byte("RIFF")) && bytes.Equal(header[8:12], []byte("WEBP")):
+		return "image/webp"
+	default:
+		return ""
+	}
+}
+
+func readImageMagic(r io.Reader) ([]byte, error) {
+	header := make([]byte, ImageMagicLength)
+	n, err := io.ReadFull(r, header)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		err = nil
+	}
+	return header[:n], err
+}
+
+// Checks that r holds a complete image of a supported type. WebP images can't
+// be decoded, so only their size is checked against the one in their header.
+func verifyImage(r io.Reader) error {
+	header, err := readImageMagic(r)
+	if err != nil {
+		return err
+	}
+
+	switch imageTypeFromMagic(header) {
+	case "":
+		return fmt.Errorf("Not a supported image")
+
+	case "image/webp":
+		expectedSize := int64(binary.LittleEndian.Uint32(header[4:8])) + 8
+		size, err := io.Copy(io.Discard, r)
+		if err != nil {
+			return err
+		}
+
+		size += int64(len(header))
+		if size < expectedSize {
+			return fmt.Errorf("Truncated WebP image (%d of %d bytes)", size, expectedSize)
+		}
+
+		return nil
+
+	default:
+		_, _, err = image.Decode(io.MultiReader(bytes.NewReader(header), r))
+		return err
+	}
+}
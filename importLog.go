@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+type ImportLogEntry struct {
+	SourcePath string
+	Signature  string
+	DoujinId   int // 0 if the import failed
+	Error      string
+	Date       time.Time
+}
+
+func (entry ImportLogEntry) Succeeded() bool {
+	return entry.Error == ""
+}
+
+func (db *Database) GetImportLogEntry(sourcePath string) (ImportLogEntry, bool, error) {
+	var entry ImportLogEntry
+	var doujinId sql.NullInt64
+	var date string
+	err := db.db.QueryRow(
+		`SELECT source_path, signature, doujin_id, error, date FROM ImportLog WHERE source_path = ?`,
+		sourcePath,
+	).Scan(&entry.SourcePath, &entry.Signature, &doujinId, &entry.Error, &date)
+
+	if err == sql.ErrNoRows {
+		return ImportLogEntry{}, false, nil
+	}
+
+	if err != nil {
+		return ImportLogEntry{}, false, err
+	}
+
+	entry.DoujinId = int(doujinId.Int64)
+	entry.Date, _ = time.Parse(time.RFC3339, date)
+
+	return entry, true, nil
+}
+
+func (db *Database) WriteImportLogEntry(entry ImportLogEntry) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = writeImportLogEntry(tx, entry)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func writeImportLogEntry(tx *sql.Tx, entry ImportLogEntry) error {
+	var doujinId sql.NullInt64
+	if entry.DoujinId != 0 {
+		doujinId = sql.NullInt64{Int64: int64(entry.DoujinId), Valid: true}
+	}
+
+	_, err := tx.Exec(
+		`INSERT INTO ImportLog (source_path, signature, doujin_id, error, date) VALUES (?, ?, ?, ?, ?)
+		 ON CONFLICT (source_path) DO UPDATE SET
+			signature = excluded.signature,
+			doujin_id = excluded.doujin_id,
+			error = excluded.error,
+			date = excluded.date`,
+		entry.SourcePath, entry.Signature, doujinId, entry.Error, entry.Date.Format(time.RFC3339),
+	)
+	return err
+}
+
+func (db *Database) GetImportLog(onlyFailed bool) ([]ImportLogEntry, error) {
+	rows, err := db.db.Query(
+		`SELECT source_path, signature, doujin_id, error, date FROM ImportLog
+		 WHERE (? = 0 OR error != '')
+		 ORDER BY date, id`,
+		onlyFailed,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := []ImportLogEntry{}
+	for rows.Next() {
+		var entry ImportLogEntry
+		var doujinId sql.NullInt64
+		var date string
+
+		err = rows.Scan(&entry.SourcePath, &entry.Signature, &doujinId, &entry.Error, &date)
+		if err != nil {
+			return nil, err
+		}
+
+		entry.DoujinId = int(doujinId.Int64)
+		entry.Date, _ = time.Parse(time.RFC3339, date)
+
+		entries = append(entries, entry)
+	}
+
+	return entries, rows.Err()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	LibraryModeCopy     = "copy"
+	LibraryModeHardlink = "hardlink"
+)
+
+// Pages in the managed library are named after their content hash, so
+// identical pages are only stored once.
+func libraryPagePath(libraryPath string, contentHash string, name string) string {
+	extension := strings.ToLower(path.Ext(name))
+	return filepath.Join(libraryPath, contentHash[:2], contentHash+extension)
+}
+
+func (db *Database) isLibraryEnabled() bool {
+	return db.serverConfig.LibraryPath != ""
+}
+
+func (db *Database) isManagedPath(filePath string) bool {
+	return db.isLibraryEnabled() && strings.HasPrefix(filePath, db.serverConfig.LibraryPath+string(filepath.Separator))
+}
+
+// The files of the library used by an import that isn't stored yet
+type pendingLibraryFiles struct {
+	used    []string
+	created []string
+}
+
+// Stores a page of source in the managed library, returning its location
+// there and whether its file was created, as identical pages share one. The
+// file is kept from being removed until it's released with
+// releaseLibraryFiles.
+func (db *Database) storePageInLibrary(source *doujinSource, page importPage) (PageFile, bool, error) {
+	destinationPath := libraryPagePath(db.serverConfig.LibraryPath, page.ContentHash, page.Name)
+	libraryPage := PageFile{
+		Path:         destinationPath,
+		ArchiveEntry: "",
+	}
+
+	err := os.MkdirAll(filepath.Dir(destinationPath), 0755)
+	if err != nil {
+		return PageFile{}, false, err
+	}
+
+	db.libraryMutex.Lock()
+	_, err = os.Stat(destinationPath)
+	if err == nil {
+		db.libraryReferences[destinationPath]++
+		db.libraryMutex.Unlock()
+		return libraryPage, false, nil
+	}
+
+	if db.serverConfig.LibraryMode == LibraryModeHardlink && !source.IsArchive() {
+		err = os.Link(page.File.Path, destinationPath)
+		if err == nil || errors.Is(err, os.ErrExist) {
+			db.libraryReferences[destinationPath]++
+			db.libraryMutex.Unlock()
+			return libraryPage, err == nil, nil
+		}
+		// Hard links don't work across file systems, so fall back to copying
+	}
+	db.libraryMutex.Unlock()
+
+	file, err := source.fsys.Open(page.Name)
+	if err != nil {
+		return PageFile{}, false, err
+	}
+	defer file.Close()
+
+	// Write to a temporary file first so a page in the library is never
+	// incomplete.
+	temporaryFile, err := os.CreateTemp(filepath.Dir(destinationPath), ".import-*")
+	if err != nil {
+		return PageFile{}, false, err
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	_, err = io.Copy(temporaryFile, file)
+	if err == nil {
+		err = temporaryFile.Chmod(0644)
+	}
+	if err != nil {
+		temporaryFile.Close()
+		return PageFile{}, false, err
+	}
+
+	err = temporaryFile.Close()
+	if err != nil {
+		return PageFile{}, false, err
+	}
+
+	db.libraryMutex.Lock()
+	defer db.libraryMutex.Unlock()
+
+	err = os.Rename(temporaryFile.Name(), destinationPath)
+	if err != nil {
+		return PageFile{}, false, err
+	}
+	db.libraryReferences[destinationPath]++
+
+	return libraryPage, true, nil
+}
+
+// Releases the library files of an import once it's done. If it wasn't
+// stored, the files it created are removed, unless something else uses them.
+func (db *Database) releaseLibraryFiles(files pendingLibraryFiles, stored bool) {
+	db.libraryMutex.Lock()
+	defer db.libraryMutex.Unlock()
+
+	for _, filePath := range files.used {
+		db.libraryReferences[filePath]--
+		if db.libraryReferences[filePath] <= 0 {
+			delete(db.libraryReferences, filePath)
+		}
+	}
+
+	if stored {
+		return
+	}
+
+	for _, filePath := range files.created {
+		_, err := db.removeUnusedLibraryFile(filePath)
+		if err != nil {
+			log.Printf("Failed to remove `%s` from the library: %v\n", filePath, err)
+		}
+	}
+}
+
+// Removes the files of the library that no page points to and no import is
+// using.
+func (db *Database) removeUnusedLibraryFiles(filePaths []string) {
+	db.libraryMutex.Lock()
+	defer db.libraryMutex.Unlock()
+
+	for _, filePath := range filePaths {
+		_, err := db.removeUnusedLibraryFile(filePath)
+		if err != nil {
+			log.Printf("Failed to remove `%s` from the library: %v\n", filePath, err)
+		}
+	}
+}
+
+// Removes filePath from the library if no page points to it and no import is
+// using it, and returns whether it was removed. Must be called with
+// libraryMutex locked.
+func (db *Database) removeUnusedLibraryFile(filePath string) (bool, error) {
+	if db.libraryReferences[filePath] > 0 {
+		return false, nil
+	}
+
+	// Identical pages of other doujins share the same file
+	err := db.db.QueryRow(`SELECT 1 FROM DoujinPages WHERE page_path = ? LIMIT 1`, filePath).Scan(new(int))
+	if err != sql.ErrNoRows {
+		return false, err
+	}
+
+	err = os.Remove(filePath)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, err
+	}
+
+	return true, nil
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"mime"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
@@ -13,6 +14,8 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
+	"strings"
 	"time"
 )
 
@@ -229,17 +232,33 @@ func getUsername(db *Database) http.HandlerFunc {
 }
 
 type SearchDoujinsRequest struct {
-	Query      string   `json:"query"`
-	PageSize   int      `json:"page_size"`
-	PageNumber int      `json:"page_number"`
-	Tags       []string `json:"tags"`
-	AntiTags   []string `json:"anti_tags"`
+	Query          string   `json:"query"`
+	PageSize       int      `json:"page_size"`
+	PageNumber     int      `json:"page_number"`
+	Tags           []string `json:"tags"`
+	AntiTags       []string `json:"anti_tags"`
+	Artists        []string `json:"artists"`
+	AntiArtists    []string `json:"anti_artists"`
+	Groups         []string `json:"groups"`
+	AntiGroups     []string `json:"anti_groups"`
+	Characters     []string `json:"characters"`
+	AntiCharacters []string `json:"anti_characters"`
+	Languages      []string `json:"languages"`
+	AntiLanguages  []string `json:"anti_languages"`
+	Sort           string   `json:"sort"`
+	Order          string   `json:"order"`
+	Seed           int64    `json:"seed"`
 }
 
 type SearchDoujinsResponse struct {
 	Results SearchResult `json:"results"`
 }
 
+type SearchQueryErrorData struct {
+	Position int    `json:"position"`
+	Message  string `json:"message"`
+}
+
 func searchDoujins(db *Database) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		username, token := getAuthData(r)
@@ -251,9 +270,40 @@ func searchDoujins(db *Database) http.HandlerFunc {
 
 		results, err := db.SearchDoujins(
 			username, token,
-			searchReq.Query, searchReq.Tags, searchReq.AntiTags,
+			searchReq.Query,
+			SearchFilters{
+				Tags:           searchReq.Tags,
+				AntiTags:       searchReq.AntiTags,
+				Artists:        searchReq.Artists,
+				AntiArtists:    searchReq.AntiArtists,
+				Groups:         searchReq.Groups,
+				AntiGroups:     searchReq.AntiGroups,
+				Characters:     searchReq.Characters,
+				AntiCharacters: searchReq.AntiCharacters,
+				Languages:      searchReq.Languages,
+				AntiLanguages:  searchReq.AntiLanguages,
+			},
+			SearchSort{
+				By:    searchReq.Sort,
+				Order: searchReq.Order,
+				Seed:  searchReq.Seed,
+			},
 			searchReq.PageSize, searchReq.PageNumber,
 		)
+
+		var queryErr *SearchQueryError
+		if errors.As(err, &queryErr) {
+			WriteResponseHttp(Response{
+				ErrorCode:   int(DatabaseErrorInvalidSearchQuery),
+				ErrorString: DatabaseErrorInvalidSearchQuery.Error(),
+				Data: SearchQueryErrorData{
+					Position: queryErr.Position,
+					Message:  queryErr.Message,
+				},
+			}, http.StatusBadRequest, w)
+			return
+		}
+
 		if err != nil {
 			errorToHttpError(w, err)
 			return
@@ -302,8 +352,41 @@ func getDoujin(db *Database) http.HandlerFunc {
 	}
 }
 
+func writePageFile(w http.ResponseWriter, pageFile PageFile) {
+	file, err := pageFile.Open()
+	if err != nil {
+		errorToHttpError(w, err)
+		return
+	}
+	defer file.Close()
+
+	writePage(w, file, pageFile)
+}
+
+// Writes the already opened file of pageFile.
+func writePage(w http.ResponseWriter, file io.Reader, pageFile PageFile) {
+	contentType := imageTypeFromExtension(pageFile.Name())
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	h := w.Header()
+	h.Del("Content-Length")
+	h.Set("Content-Type", contentType)
+	h.Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	_, err := io.Copy(w, file)
+	if err != nil {
+		log.Printf("Failed to stream file `%s`: %v", pageFile, err)
+	}
+}
+
 type GetPageRequest struct {
-	PageId int `json:"page_id"`
+	PageId    int `json:"page_id"`
+	MaxWidth  int `json:"max_width"`
+	MaxHeight int `json:"max_height"`
+	Quality   int `json:"quality"`
 }
 
 func getPage(db *Database) http.HandlerFunc {
@@ -315,43 +398,270 @@ func getPage(db *Database) http.HandlerFunc {
 			return
 		}
 
-		imagePath, err := db.GetPageFilePath(username, token, pageReq.PageId)
+		resizeOptions := ResizeOptions{
+			MaxWidth:  pageReq.MaxWidth,
+			MaxHeight: pageReq.MaxHeight,
+			Quality:   pageReq.Quality,
+		}
+
+		if resizeOptions.IsZero() {
+			pageFile, err := db.GetPageFile(username, token, pageReq.PageId)
+			if err != nil {
+				errorToHttpError(w, err)
+				return
+			}
+
+			writePageFile(w, pageFile)
+			return
+		}
+
+		file, pageFile, err := db.GetResizedPage(username, token, pageReq.PageId, resizeOptions)
+		if err != nil {
+			errorToHttpError(w, err)
+			return
+		}
+		defer file.Close()
+
+		writePage(w, file, pageFile)
+	}
+}
+
+type GetThumbnailRequest struct {
+	PageId int `json:"page_id"`
+	Size   int `json:"size"`
+}
+
+func getThumbnail(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, token := getAuthData(r)
+
+		var thumbnailReq GetThumbnailRequest
+		if !decodeJson(r.Body, &thumbnailReq, w) {
+			return
+		}
+
+		pageFile, err := db.GetThumbnail(username, token, thumbnailReq.PageId, thumbnailReq.Size)
 		if err != nil {
 			errorToHttpError(w, err)
 			return
 		}
 
-		file, err := os.Open(imagePath)
+		writePageFile(w, pageFile)
+	}
+}
+
+type GetSimilarDoujinsRequest struct {
+	MaxDistance *int `json:"max_distance"`
+}
+
+type GetSimilarDoujinsResponse struct {
+	Groups [][]DoujinListing `json:"groups"`
+}
+
+func getSimilarDoujins(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, token := getAuthData(r)
+
+		var similarReq GetSimilarDoujinsRequest
+		if !decodeJson(r.Body, &similarReq, w) {
+			return
+		}
+
+		groups, err := db.GetSimilarDoujins(username, token, similarReq.MaxDistance)
 		if err != nil {
 			errorToHttpError(w, err)
 			return
 		}
-		defer file.Close()
 
-		var contentType string
-		switch path.Ext(imagePath) {
-		case ".gif":
-			contentType = "image/gif"
-		case ".jpg", ".jpeg":
-			contentType = "image/jpeg"
-		case ".png":
-			contentType = "image/png"
-		case ".webp":
-			contentType = "image/webp"
-		default:
-			contentType = "application/octet-stream"
+		WriteResponseHttp(Response{
+			ErrorCode:   0,
+			ErrorString: "OK",
+			Data: GetSimilarDoujinsResponse{
+				Groups: groups,
+			},
+		}, http.StatusOK, w)
+	}
+}
+
+type GetSeriesRequest struct {
+	SeriesId int `json:"series_id"`
+}
+
+type GetSeriesResponse struct {
+	Series Series `json:"series"`
+}
+
+func getSeries(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, token := getAuthData(r)
+
+		var seriesReq GetSeriesRequest
+		if !decodeJson(r.Body, &seriesReq, w) {
+			return
+		}
+
+		series, err := db.GetSeries(username, token, seriesReq.SeriesId)
+		if err != nil {
+			errorToHttpError(w, err)
+			return
+		}
+
+		WriteResponseHttp(Response{
+			ErrorCode:   0,
+			ErrorString: "OK",
+			Data: GetSeriesResponse{
+				Series: series,
+			},
+		}, http.StatusOK, w)
+	}
+}
+
+type GetAdjacentChapterRequest struct {
+	DoujinId int `json:"doujin_id"`
+}
+
+type GetAdjacentChapterResponse struct {
+	Chapter SeriesChapter `json:"chapter"`
+}
+
+// Handles both /api/v1/nextChapter and /api/v1/previousChapter.
+func getAdjacentChapter(db *Database, next bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, token := getAuthData(r)
+
+		var chapterReq GetAdjacentChapterRequest
+		if !decodeJson(r.Body, &chapterReq, w) {
+			return
+		}
+
+		chapter, err := db.GetAdjacentChapter(username, token, chapterReq.DoujinId, next)
+		if err != nil {
+			errorToHttpError(w, err)
+			return
+		}
+
+		WriteResponseHttp(Response{
+			ErrorCode:   0,
+			ErrorString: "OK",
+			Data: GetAdjacentChapterResponse{
+				Chapter: chapter,
+			},
+		}, http.StatusOK, w)
+	}
+}
+
+type DownloadDoujinRequest struct {
+	DoujinId int `json:"doujin_id"`
+}
+
+func downloadDoujin(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, token := getAuthData(r)
+
+		var downloadReq DownloadDoujinRequest
+		if !decodeJson(r.Body, &downloadReq, w) {
+			return
+		}
+
+		download, err := db.GetDoujinDownload(username, token, downloadReq.DoujinId)
+		if err != nil {
+			errorToHttpError(w, err)
+			return
 		}
 
 		h := w.Header()
 		h.Del("Content-Length")
-		h.Set("Content-Type", contentType)
+		h.Set("Content-Type", "application/vnd.comicbook+zip")
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": download.FileName()}))
 		h.Set("X-Content-Type-Options", "nosniff")
 		w.WriteHeader(http.StatusOK)
 
-		_, err = io.Copy(w, file)
+		// The status was already sent, so the client only sees a truncated
+		// archive
+		err = download.WriteArchive(w)
+		if err != nil {
+			log.Printf("Failed to stream doujin %d: %v", download.Doujin.Id, err)
+		}
+	}
+}
+
+type UploadDoujinResponse struct {
+	DoujinId int `json:"doujin_id"`
+}
+
+type UploadDoujinErrorData struct {
+	Problems []string `json:"problems"`
+}
+
+func uploadDoujin(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, token := getAuthData(r)
+
+		form, err := r.MultipartReader()
+		if err != nil {
+			errorToHttpError(w, DatabaseErrorInvalidUpload)
+			return
+		}
+
+		doujinId, err := db.UploadDoujin(username, token, form)
+
+		var validationErr *ValidationError
+		if errors.As(err, &validationErr) {
+			problems := []string{}
+			for _, problem := range validationErr.Problems {
+				problems = append(problems, problem.Error())
+			}
+
+			WriteResponseHttp(Response{
+				ErrorCode:   int(DatabaseErrorInvalidDoujin),
+				ErrorString: DatabaseErrorInvalidDoujin.Error(),
+				Data: UploadDoujinErrorData{
+					Problems: problems,
+				},
+			}, http.StatusBadRequest, w)
+			return
+		}
+
+		if err != nil {
+			errorToHttpError(w, err)
+			return
+		}
+
+		WriteResponseHttp(Response{
+			ErrorCode:   0,
+			ErrorString: "OK",
+			Data: UploadDoujinResponse{
+				DoujinId: doujinId,
+			},
+		}, http.StatusOK, w)
+	}
+}
+
+type DeleteDoujinRequest struct {
+	DoujinId    int  `json:"doujin_id"`
+	DeleteFiles bool `json:"delete_files"`
+}
+
+func deleteDoujin(db *Database) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username, token := getAuthData(r)
+
+		var deleteReq DeleteDoujinRequest
+		if !decodeJson(r.Body, &deleteReq, w) {
+			return
+		}
+
+		err := db.DeleteDoujin(username, token, deleteReq.DoujinId, deleteReq.DeleteFiles)
 		if err != nil {
-			log.Printf("Failed to stream file `%s`: %v", imagePath, err)
+			errorToHttpError(w, err)
+			return
 		}
+
+		WriteResponseHttp(Response{
+			ErrorCode:   0,
+			ErrorString: "OK",
+			Data:        nil,
+		}, http.StatusOK, w)
 	}
 }
 
@@ -518,6 +828,14 @@ func startServer(serverConfig ServerConfig) {
 	}
 	defer db.Close()
 
+	if len(serverConfig.WatchDirectories) > 0 {
+		watcher := NewDirectoryWatcher(
+			db, serverConfig.WatchDirectories,
+			time.Duration(serverConfig.WatchSettleSeconds)*time.Second,
+		)
+		go watcher.Run()
+	}
+
 	// Authentication
 	http.HandleFunc("/api/v1/register", Method(registerUser(db), "POST"))
 	http.HandleFunc("/api/v1/login", Method(loginUser(db), "POST"))
@@ -528,6 +846,14 @@ func startServer(serverConfig ServerConfig) {
 	http.HandleFunc("/api/v1/search", Method(searchDoujins(db), "POST"))
 	http.HandleFunc("/api/v1/doujin", Method(getDoujin(db), "POST"))
 	http.HandleFunc("/api/v1/page", Method(getPage(db), "POST"))
+	http.HandleFunc("/api/v1/thumbnail", Method(getThumbnail(db), "POST"))
+	http.HandleFunc("/api/v1/similarDoujins", Method(getSimilarDoujins(db), "POST"))
+	http.HandleFunc("/api/v1/series", Method(getSeries(db), "POST"))
+	http.HandleFunc("/api/v1/nextChapter", Method(getAdjacentChapter(db, true), "POST"))
+	http.HandleFunc("/api/v1/previousChapter", Method(getAdjacentChapter(db, false), "POST"))
+	http.HandleFunc("/api/v1/downloadDoujin", Method(downloadDoujin(db), "POST"))
+	http.HandleFunc("/api/v1/uploadDoujin", Method(uploadDoujin(db), "POST"))
+	http.HandleFunc("/api/v1/deleteDoujin", Method(deleteDoujin(db), "POST"))
 
 	// Tags
 	http.HandleFunc("/api/v1/tags", Method(getTags(db), "POST"))
@@ -548,16 +874,100 @@ func startServer(serverConfig ServerConfig) {
 func manageUsage(out io.Writer, programName string) {
 	fmt.Fprintf(out, "USAGE: %s manage <COMMAND>\n", programName)
 	fmt.Fprintf(out, "    COMMANDs:\n")
-	fmt.Fprintf(out, "        import-doujin <FOLDER>               Imports the doujin in FOLDER to the database.\n")
-	fmt.Fprintf(out, "                                             The folder must contain a metadata.json file\n")
-	fmt.Fprintf(out, "                                             (see meta-format) and a sequence of image files named from 1\n")
-	fmt.Fprintf(out, "                                             to N (including the extension), with each file being a page.\n")
-	fmt.Fprintf(out, "                                             The numbers can be padded with zeroes.\n")
-	fmt.Fprintf(out, "        import-doujins-from <FOLDER>         Imports all doujins in FOLDER to the database.\n")
-	fmt.Fprintf(out, "                                             The folder must contain subfolders, each with a metadata.json\n")
-	fmt.Fprintf(out, "                                             file (see meta-format) and a sequence image files named from 1\n")
-	fmt.Fprintf(out, "                                             to N (including the extension), with each file being a page.\n")
-	fmt.Fprintf(out, "                                             The numbers can be padded with zeroes.\n")
+	fmt.Fprintf(out, "        import-doujin [--metadata-format FORMAT] [--natural-sort] <FOLDER>\n")
+	fmt.Fprintf(out, "                                             Imports the doujin in FOLDER to the database.\n")
+	fmt.Fprintf(out, "                                             The folder must contain a metadata file (see meta-format)\n")
+	fmt.Fprintf(out, "                                             and a sequence of image files named from 1 to N (including\n")
+	fmt.Fprintf(out, "                                             the extension), with each file being a page. The numbers can\n")
+	fmt.Fprintf(out, "                                             be padded with zeroes. FOLDER can also be a CBZ/ZIP archive\n")
+	fmt.Fprintf(out, "                                             with the same contents. The metadata file's format is\n")
+	fmt.Fprintf(out, "                                             detected, unless it's given with --metadata-format. With\n")
+	fmt.Fprintf(out, "                                             --natural-sort, every image file is a page instead, whatever\n")
+	fmt.Fprintf(out, "                                             its name, and pages are ordered by natural sort (so\n")
+	fmt.Fprintf(out, "                                             `page_2.jpg` comes before `page_10.jpg`). Pages listed in the\n")
+	fmt.Fprintf(out, "                                             metadata file's \"page_files\" always take precedence.\n")
+	fmt.Fprintf(out, "        import-doujins-from [--jobs N] [--report FILE] [--metadata-format FORMAT] [--natural-sort]\n")
+	fmt.Fprintf(out, "                            <FOLDER>\n")
+	fmt.Fprintf(out, "                                             Imports all doujins in FOLDER to the database.\n")
+	fmt.Fprintf(out, "                                             The folder must contain subfolders or CBZ/ZIP archives, each\n")
+	fmt.Fprintf(out, "                                             with a metadata file (see meta-format) and a sequence\n")
+	fmt.Fprintf(out, "                                             image files named from 1 to N (including the extension), with\n")
+	fmt.Fprintf(out, "                                             each file being a page. The numbers can be padded with zeroes.\n")
+	fmt.Fprintf(out, "                                             Up to N doujins are imported at the same time (defaults to\n")
+	fmt.Fprintf(out, "                                             the number of CPUs). Doujins that fail to import don't stop\n")
+	fmt.Fprintf(out, "                                             the others, and are listed at the end. With --report, the\n")
+	fmt.Fprintf(out, "                                             summary is also written to FILE as JSON. --metadata-format\n")
+	fmt.Fprintf(out, "                                             and --natural-sort work like in import-doujin.\n")
+	fmt.Fprintf(out, "        import-series [--title TITLE] [--metadata-format FORMAT] [--natural-sort] <FOLDER>\n")
+	fmt.Fprintf(out, "                                             Imports every subfolder or CBZ/ZIP archive in FOLDER as a\n")
+	fmt.Fprintf(out, "                                             chapter of a new series called TITLE (defaults to the name\n")
+	fmt.Fprintf(out, "                                             of FOLDER). Each chapter must be importable by itself with\n")
+	fmt.Fprintf(out, "                                             import-doujin, and chapters are ordered by natural sort of\n")
+	fmt.Fprintf(out, "                                             their names. Nothing is imported if any chapter is invalid.\n")
+	fmt.Fprintf(out, "                                             --metadata-format and --natural-sort work like in\n")
+	fmt.Fprintf(out, "                                             import-doujin.\n")
+	fmt.Fprintf(out, "        create-series <TITLE> <ID...>        Creates a series called TITLE whose chapters are the\n")
+	fmt.Fprintf(out, "                                             doujins with the IDs ID..., in order, and prints its ID.\n")
+	fmt.Fprintf(out, "                                             Doujins in another series are moved out of it.\n")
+	fmt.Fprintf(out, "        set-chapter <ID> <SERIES_ID> <CHAPTER_NUMBER>\n")
+	fmt.Fprintf(out, "                                             Makes the doujin with ID ID chapter CHAPTER_NUMBER of the\n")
+	fmt.Fprintf(out, "                                             series with ID SERIES_ID, like to add a new chapter to an\n")
+	fmt.Fprintf(out, "                                             existing series. Each chapter number can only be used once.\n")
+	fmt.Fprintf(out, "        update-doujin [--rescan] [--metadata-format FORMAT] [--natural-sort] <ID>\n")
+	fmt.Fprintf(out, "                      <FOLDER|METADATA_FILE>\n")
+	fmt.Fprintf(out, "                                             Replaces the metadata of the doujin with ID ID with the\n")
+	fmt.Fprintf(out, "                                             metadata of the doujin in FOLDER (or CBZ/ZIP archive), or\n")
+	fmt.Fprintf(out, "                                             with the metadata in METADATA_FILE (see meta-format), and\n")
+	fmt.Fprintf(out, "                                             prints what changed. With --rescan, the doujin's pages are\n")
+	fmt.Fprintf(out, "                                             replaced by the pages in FOLDER too, keeping their IDs, and\n")
+	fmt.Fprintf(out, "                                             the doujin is made available again. --metadata-format and\n")
+	fmt.Fprintf(out, "                                             --natural-sort work like in import-doujin.\n")
+	fmt.Fprintf(out, "        delete-doujin [--delete-files] <ID...>\n")
+	fmt.Fprintf(out, "                                             Deletes the doujins with the IDs ID... and their pages from\n")
+	fmt.Fprintf(out, "                                             the database. With --delete-files, the page files stored in\n")
+	fmt.Fprintf(out, "                                             the library directory (see library_path in the config) that\n")
+	fmt.Fprintf(out, "                                             aren't used by other doujins are deleted too. Files outside\n")
+	fmt.Fprintf(out, "                                             the library directory are never deleted.\n")
+	fmt.Fprintf(out, "        validate [--metadata-format FORMAT] [--natural-sort] <FOLDER>\n")
+	fmt.Fprintf(out, "                                             Checks the doujin in FOLDER with the same rules used by\n")
+	fmt.Fprintf(out, "                                             import-doujin, without importing it, and lists every problem\n")
+	fmt.Fprintf(out, "                                             found. FOLDER can also be a CBZ/ZIP archive or, when it has\n")
+	fmt.Fprintf(out, "                                             no metadata file, a folder of doujins like the ones accepted\n")
+	fmt.Fprintf(out, "                                             by import-doujins-from. --metadata-format and --natural-sort\n")
+	fmt.Fprintf(out, "                                             work like in import-doujin.\n")
+	fmt.Fprintf(out, "        find-duplicates                      Lists groups of doujins that have exactly the same pages.\n")
+	fmt.Fprintf(out, "                                             Pages imported before page hashes were stored get hashed\n")
+	fmt.Fprintf(out, "                                             first.\n")
+	fmt.Fprintf(out, "        find-similar [--distance N]          Lists groups of doujins whose covers and sampled pages look\n")
+	fmt.Fprintf(out, "                                             alike, like re-encoded, resized or watermarked copies. Pages\n")
+	fmt.Fprintf(out, "                                             are compared by perceptual hash, and considered alike when\n")
+	fmt.Fprintf(out, "                                             their hashes differ in at most N bits (defaults to the\n")
+	fmt.Fprintf(out, "                                             similarity_max_distance configuration option). Missing\n")
+	fmt.Fprintf(out, "                                             hashes are computed first.\n")
+	fmt.Fprintf(out, "        backfill-page-info                   Records the dimensions, size and MIME type of pages imported\n")
+	fmt.Fprintf(out, "                                             before they were recorded at import time.\n")
+	fmt.Fprintf(out, "        generate-thumbnails [--all-pages]    Generates the thumbnails of every configured size (see\n")
+	fmt.Fprintf(out, "                                             thumbnail_sizes in the config) for the cover of every\n")
+	fmt.Fprintf(out, "                                             doujin, so they don't have to be generated when first\n")
+	fmt.Fprintf(out, "                                             requested. With --all-pages, for every page.\n")
+	fmt.Fprintf(out, "        fsck [--repair] [--new-root DIR]     Checks that every page in the database exists and is a valid\n")
+	fmt.Fprintf(out, "                                             image, that every doujin has as many pages as its metadata\n")
+	fmt.Fprintf(out, "                                             says, and that there are no orphaned pages or import log\n")
+	fmt.Fprintf(out, "                                             entries. With --repair, orphans are removed and doujins with\n")
+	fmt.Fprintf(out, "                                             problems are marked as unavailable, hiding them from users,\n")
+	fmt.Fprintf(out, "                                             until a later repair finds them fixed. With --new-root,\n")
+	fmt.Fprintf(out, "                                             missing pages are looked for under DIR first, for when their\n")
+	fmt.Fprintf(out, "                                             files were moved there.\n")
+	fmt.Fprintf(out, "        relocate [--apply] <OLD_PREFIX> <NEW_PREFIX>\n")
+	fmt.Fprintf(out, "                                             Replaces the OLD_PREFIX directory with NEW_PREFIX in the\n")
+	fmt.Fprintf(out, "                                             stored paths of every page and import log entry, for when\n")
+	fmt.Fprintf(out, "                                             doujins were moved from OLD_PREFIX to NEW_PREFIX. Only shows\n")
+	fmt.Fprintf(out, "                                             what would change, unless --apply is given. Nothing changes\n")
+	fmt.Fprintf(out, "                                             if any page doesn't exist at its new path.\n")
+	fmt.Fprintf(out, "        import-log [--failed]                Lists every imported doujin with the folder or archive it\n")
+	fmt.Fprintf(out, "                                             was imported from, and the ones in the watched directories\n")
+	fmt.Fprintf(out, "                                             that failed to import, with the reason. With --failed, only\n")
+	fmt.Fprintf(out, "                                             lists the failures.\n")
 	fmt.Fprintf(out, "        register-user <USERNAME> <PASSWORD>  Registers a new user with username USERNAME and password\n")
 	fmt.Fprintf(out, "                                             PASSWORD.\n")
 	fmt.Fprintf(out, "        help                                 Prints this help.\n")
@@ -567,7 +977,7 @@ func usage(out io.Writer, programName string) {
 	fmt.Fprintf(out, "USAGE: %s <SUBCOMMAND>\n", programName)
 	fmt.Fprintf(out, "SUBCOMMANDs:\n")
 	fmt.Fprintf(out, "    help              Prints this help.\n")
-	fmt.Fprintf(out, "    meta-format       Prints help for the format of the metadata.json file used for\n")
+	fmt.Fprintf(out, "    meta-format       Prints help for the formats of the metadata files used for\n")
 	fmt.Fprintf(out, "                      importing doujins.\n")
 	fmt.Fprintf(out, "    start             Starts the server.\n")
 	fmt.Fprintf(out, "    manage <COMMAND>  Manage users and doujins. Provide `help` as a command to list\n")
@@ -586,6 +996,27 @@ func popArg() string {
 	return arg
 }
 
+// Applies flag to options if it's one of the flags shared by the import
+// commands, popping its argument. Returns whether it was one.
+func popImportOption(programName string, flag string, options *ImportOptions) bool {
+	switch flag {
+	case "--metadata-format":
+		options.MetadataFormat = popArg()
+		if metadataAdapterByName(options.MetadataFormat) == nil {
+			fmt.Fprintf(os.Stderr, "ERROR: --metadata-format expects one of %s\n", strings.Join(metadataAdapterNames(), ", "))
+			manageUsage(os.Stderr, programName)
+			os.Exit(1)
+		}
+		return true
+
+	case "--natural-sort":
+		options.NaturalSort = true
+		return true
+	}
+
+	return false
+}
+
 func main() {
 	programName := popArg()
 
@@ -628,7 +1059,7 @@ func main() {
 		fmt.Println("  was downloaded from. It usually represents a number of views, likes, favorites, etc;")
 		fmt.Println("- `\"upload_date\"` is either the date the doujin was uploaded to the external website")
 		fmt.Println("  it was downloaded from or the date the doujin was first published or imported. The")
-		fmt.Println("  date is in RFC 3339 format;")
+		fmt.Println("  date is in RFC 3339 format, and is converted to UTC when imported;")
 		fmt.Println("- `\"character\"` is an array containing the doujin's main characters;")
 		fmt.Println("- `\"tag\"` is an array containing the doujin's tags;")
 		fmt.Println("- `\"artist\"` is an array containing the names of the artists that worked on the")
@@ -636,6 +1067,55 @@ func main() {
 		fmt.Println("- `\"group\"` is an array containing the names of the groups that worked on the doujin;")
 		fmt.Println("- `\"language\"` is an array containing the languages used in the doujin;")
 		fmt.Println("- `\"Pages\"` is the number of pages of the doujin.")
+		fmt.Println("")
+		fmt.Println("The file can also have a `\"page_files\"` field, an array with the names of the page")
+		fmt.Println("files in page order, like `[\"cover.jpg\", \"p001.jpg\", \"p002.jpg\"]`. When it's")
+		fmt.Println("present, those files are the pages, whatever their names, instead of the files named")
+		fmt.Println("from 1 to N. Names are relative to the doujin's folder or archive.")
+		fmt.Println("")
+		fmt.Println("The ComicInfo.xml File Format")
+		fmt.Println("")
+		fmt.Println("When a doujin has no JSON metadata file, a ComicInfo.xml file (as used by ComicRack,")
+		fmt.Println("Komga, etc) is read instead. Its fields are mapped to the fields of metadata.json as")
+		fmt.Println("follows:")
+		fmt.Println("")
+		fmt.Println("- `<Title>` becomes `\"title\"`. If it's missing, `<Series>` is used instead;")
+		fmt.Println("- `<Series>` becomes `\"subtitle\"`, unless it was used as the title or is the same")
+		fmt.Println("  as the title;")
+		fmt.Println("- `<Writer>` and `<Penciller>` become `\"artist\"`;")
+		fmt.Println("- `<Tags>` becomes `\"tag\"`;")
+		fmt.Println("- `<Characters>` becomes `\"character\"`;")
+		fmt.Println("- `<Teams>` becomes `\"group\"`;")
+		fmt.Println("- `<LanguageISO>` becomes `\"language\"`. Common ISO 639-1 codes are converted to")
+		fmt.Println("  language names (`en` becomes `english`), and other codes are kept as they are;")
+		fmt.Println("- `<PageCount>` becomes `\"pages\"`. If it's missing, the number of `<Page>` elements")
+		fmt.Println("  inside `<Pages>` is used instead;")
+		fmt.Println("- `<Year>`, `<Month>` and `<Day>` become `\"upload_date\"`, at midnight UTC. A")
+		fmt.Println("  missing month or day defaults to 1.")
+		fmt.Println("")
+		fmt.Println("`<Writer>`, `<Penciller>`, `<Tags>`, `<Characters>` and `<Teams>` are comma-separated")
+		fmt.Println("lists. `\"favorite_counts\"` is always 0.")
+		fmt.Println("")
+		fmt.Println("Other Metadata Formats")
+		fmt.Println("")
+		fmt.Println("The metadata of a doujin is read from the first of `metadata.json`, `info.json` and")
+		fmt.Println("`ComicInfo.xml` it has. The format of JSON files is detected from their fields, and")
+		fmt.Println("can also be given with the --metadata-format flag of the import commands. The")
+		fmt.Println("supported formats are:")
+		fmt.Println("")
+		fmt.Println("- `hv`: the metadata.json format above, used for JSON files in no other format;")
+		fmt.Println("- `comicinfo`: the ComicInfo.xml format above;")
+		fmt.Println("- `gallery-dl`: the info.json file written by gallery-dl's --write-info-json;")
+		fmt.Println("- `nhentai`: a gallery as returned by nhentai's `/api/gallery/<ID>` endpoint;")
+		fmt.Println("- `ehentai`: a gallery as returned by the `gdata` method of the E-Hentai API, either")
+		fmt.Println("  the whole response, which must have a single gallery, or the gallery alone.")
+		fmt.Println("")
+		fmt.Println("In these formats, the English or romanized title becomes `\"title\"` and the Japanese")
+		fmt.Println("one `\"subtitle\"`. Namespaced tags, like `artist:name` or nhentai's tag types, are")
+		fmt.Println("sorted into `\"artist\"`, `\"group\"`, `\"character\"` and `\"language\"`; parodies")
+		fmt.Println("and the other namespaces become plain tags, without the namespace, and categories")
+		fmt.Println("are dropped. `\"favorite_counts\"` is the number of favorites when the format has")
+		fmt.Println("it, and 0 otherwise. The number of pages must be in the file.")
 		os.Exit(0)
 
 	case "manage":
@@ -643,7 +1123,12 @@ func main() {
 
 		switch command {
 		case "import-doujin":
+			options := ImportOptions{}
 			directory := popArg()
+			for popImportOption(programName, directory, &options) {
+				directory = popArg()
+			}
+
 			if directory == "" {
 				fmt.Fprintf(os.Stderr, "ERROR: no folder was provided for importing\n")
 				manageUsage(os.Stderr, programName)
@@ -657,20 +1142,51 @@ func main() {
 			}
 			defer db.Close()
 
-			err = db.ImportDoujin(directory)
+			log.Printf("Importing doujin in `%s`\n", directory)
+			_, err = db.ImportDoujin(directory, options)
 			if err != nil {
-				fmt.Fprintf(os.Stderr, "ERROR: failed to register doujin in folder `%s`: %v\n", directory, err)
+				fmt.Fprintf(os.Stderr, "ERROR: failed to register doujin in `%s`: %v\n", directory, err)
 				os.Exit(1)
 			}
 
 			os.Exit(0)
 
 		case "import-doujins-from":
-			directory := popArg()
-			if directory == "" {
-				fmt.Fprintf(os.Stderr, "ERROR: no folder was provided for importing\n")
-				manageUsage(os.Stderr, programName)
-				os.Exit(1)
+			workers := runtime.NumCPU()
+			reportPath := ""
+			options := ImportOptions{}
+			directory := ""
+			for directory == "" {
+				arg := popArg()
+				switch arg {
+				case "--jobs":
+					jobs, err := strconv.Atoi(popArg())
+					if err != nil || jobs < 1 {
+						fmt.Fprintf(os.Stderr, "ERROR: --jobs expects a positive number\n")
+						manageUsage(os.Stderr, programName)
+						os.Exit(1)
+					}
+					workers = jobs
+
+				case "--report":
+					reportPath = popArg()
+					if reportPath == "" {
+						fmt.Fprintf(os.Stderr, "ERROR: no file was provided to --report\n")
+						manageUsage(os.Stderr, programName)
+						os.Exit(1)
+					}
+
+				case "--metadata-format", "--natural-sort":
+					popImportOption(programName, arg, &options)
+
+				case "":
+					fmt.Fprintf(os.Stderr, "ERROR: no folder was provided for importing\n")
+					manageUsage(os.Stderr, programName)
+					os.Exit(1)
+
+				default:
+					directory = arg
+				}
 			}
 
 			db, err := NewDatabase(LoadServerConfig())
@@ -686,19 +1202,646 @@ func main() {
 				os.Exit(1)
 			}
 
+			doujinPaths := []string{}
 			for _, e := range entries {
-				if !e.IsDir() {
+				if !e.IsDir() && !isArchivePath(e.Name()) {
 					continue
 				}
 
-				doujinPath := path.Join(directory, e.Name())
-				err = db.ImportDoujin(doujinPath)
+				doujinPaths = append(doujinPaths, path.Join(directory, e.Name()))
+			}
+
+			progress := NewProgressPrinter(os.Stderr, len(doujinPaths))
+			report := db.BulkImport(doujinPaths, workers, options, progress.Update)
+
+			fmt.Printf("Imported %d out of %d doujins in %s (%d failed)\n",
+				report.Imported, report.Total,
+				time.Duration(report.DurationSeconds*float64(time.Second)).Round(time.Second),
+				report.Failed)
+			for _, failure := range report.Failures {
+				fmt.Printf("FAILED `%s`: %s\n", failure.Path, failure.Error)
+			}
+
+			if reportPath != "" {
+				err = os.WriteFile(reportPath, []byte(jsonEncode(report)), 0644)
 				if err != nil {
-					fmt.Fprintf(os.Stderr, "ERROR: failed to register doujin in folder `%s`: %v\n", doujinPath, err)
+					fmt.Fprintf(os.Stderr, "ERROR: failed to write report `%s`: %v\n", reportPath, err)
 					os.Exit(1)
 				}
 			}
 
+			if report.Failed > 0 {
+				os.Exit(1)
+			}
+
+			os.Exit(0)
+
+		case "import-series":
+			title := ""
+			options := ImportOptions{}
+			directory := popArg()
+			for {
+				if directory == "--title" {
+					title = popArg()
+					if title == "" {
+						fmt.Fprintf(os.Stderr, "ERROR: --title expects a title\n")
+						manageUsage(os.Stderr, programName)
+						os.Exit(1)
+					}
+				} else if !popImportOption(programName, directory, &options) {
+					break
+				}
+				directory = popArg()
+			}
+
+			if directory == "" {
+				fmt.Fprintf(os.Stderr, "ERROR: no folder was provided for importing\n")
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			result, err := db.ImportSeries(directory, title, options)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to import series in `%s`:\n", directory)
+				for _, problem := range appendProblems(nil, err) {
+					fmt.Fprintf(os.Stderr, "    - %v\n", problem)
+				}
+				os.Exit(1)
+			}
+
+			if result.SeriesId != 0 {
+				fmt.Printf("Imported %d chapters as series %d\n", result.Imported, result.SeriesId)
+			}
+			for _, failure := range result.Failures {
+				fmt.Printf("FAILED `%s`: %s\n", failure.Path, failure.Error)
+			}
+
+			if len(result.Failures) > 0 {
+				os.Exit(1)
+			}
+
+			os.Exit(0)
+
+		case "create-series":
+			title := popArg()
+			if title == "" {
+				fmt.Fprintf(os.Stderr, "ERROR: no title was provided\n")
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			doujinIds := []int{}
+			for arg := popArg(); arg != ""; arg = popArg() {
+				doujinId, err := strconv.Atoi(arg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: invalid doujin ID `%s`\n", arg)
+					manageUsage(os.Stderr, programName)
+					os.Exit(1)
+				}
+				doujinIds = append(doujinIds, doujinId)
+			}
+
+			if len(doujinIds) == 0 {
+				fmt.Fprintf(os.Stderr, "ERROR: no doujin ID was provided\n")
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			seriesId, err := db.CreateSeries(title, doujinIds)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create series: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Created series %d with %d chapters\n", seriesId, len(doujinIds))
+			os.Exit(0)
+
+		case "set-chapter":
+			numbers := []int{}
+			for _, name := range []string{"doujin ID", "series ID", "chapter number"} {
+				arg := popArg()
+				if arg == "" {
+					fmt.Fprintf(os.Stderr, "ERROR: no %s was provided\n", name)
+					manageUsage(os.Stderr, programName)
+					os.Exit(1)
+				}
+
+				number, err := strconv.Atoi(arg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: invalid %s `%s`\n", name, arg)
+					manageUsage(os.Stderr, programName)
+					os.Exit(1)
+				}
+				numbers = append(numbers, number)
+			}
+
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			err = db.SetChapter(numbers[0], numbers[1], numbers[2])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to set chapter: %v\n", err)
+				os.Exit(1)
+			}
+
+			os.Exit(0)
+
+		case "update-doujin":
+			rescan := false
+			options := ImportOptions{}
+			arg := popArg()
+			for strings.HasPrefix(arg, "--") {
+				if arg == "--rescan" {
+					rescan = true
+				} else if !popImportOption(programName, arg, &options) {
+					fmt.Fprintf(os.Stderr, "ERROR: unknown flag `%s`\n", arg)
+					manageUsage(os.Stderr, programName)
+					os.Exit(1)
+				}
+				arg = popArg()
+			}
+
+			doujinId, err := strconv.Atoi(arg)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: no valid doujin ID was provided\n")
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			sourcePath := popArg()
+			if sourcePath == "" {
+				fmt.Fprintf(os.Stderr, "ERROR: no folder or metadata file was provided\n")
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			changes, err := db.UpdateDoujin(doujinId, sourcePath, rescan, options)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to update doujin %d: %v\n", doujinId, err)
+				os.Exit(1)
+			}
+
+			for _, change := range changes {
+				fmt.Printf("%s:\n", change.Field)
+				for _, value := range change.Removed {
+					fmt.Printf("    - %s\n", value)
+				}
+				for _, value := range change.Added {
+					fmt.Printf("    + %s\n", value)
+				}
+			}
+
+			if len(changes) == 0 {
+				fmt.Println("No changes")
+			}
+
+			os.Exit(0)
+
+		case "delete-doujin":
+			deleteFiles := false
+			doujinIds := []int{}
+			for arg := popArg(); arg != ""; arg = popArg() {
+				if arg == "--delete-files" {
+					deleteFiles = true
+					continue
+				}
+
+				doujinId, err := strconv.Atoi(arg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: invalid doujin ID `%s`\n", arg)
+					manageUsage(os.Stderr, programName)
+					os.Exit(1)
+				}
+				doujinIds = append(doujinIds, doujinId)
+			}
+
+			if len(doujinIds) == 0 {
+				fmt.Fprintf(os.Stderr, "ERROR: no doujin ID was provided\n")
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			result, err := db.DeleteDoujins(doujinIds, deleteFiles)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to delete doujins: %v\n", err)
+				os.Exit(1)
+			}
+
+			fmt.Printf("Deleted %d doujins and %d files\n", result.DeletedDoujins, len(result.DeletedFiles))
+			for _, err := range result.FileErrors {
+				fmt.Fprintf(os.Stderr, "WARNING: failed to delete file: %v\n", err)
+			}
+
+			os.Exit(0)
+
+		case "validate":
+			options := ImportOptions{}
+			directory := popArg()
+			for popImportOption(programName, directory, &options) {
+				directory = popArg()
+			}
+
+			if directory == "" {
+				fmt.Fprintf(os.Stderr, "ERROR: no folder was provided for validating\n")
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			info, err := os.Stat(directory)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to open `%s`: %v\n", directory, err)
+				os.Exit(1)
+			}
+
+			doujinPaths := []string{directory}
+			if info.IsDir() && !isDoujinFolder(directory) {
+				entries, err := os.ReadDir(directory)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: failed to read directory `%s`: %v\n", directory, err)
+					os.Exit(1)
+				}
+
+				doujinPaths = []string{}
+				for _, e := range entries {
+					if !e.IsDir() && !isArchivePath(e.Name()) {
+						continue
+					}
+
+					doujinPaths = append(doujinPaths, path.Join(directory, e.Name()))
+				}
+			}
+
+			invalidCount := 0
+			for _, doujinPath := range doujinPaths {
+				err = ValidateDoujin(doujinPath, options)
+				if err == nil {
+					fmt.Printf("OK       `%s`\n", doujinPath)
+					continue
+				}
+
+				invalidCount++
+				fmt.Printf("INVALID  `%s`\n", doujinPath)
+				for _, problem := range appendProblems(nil, err) {
+					fmt.Printf("    - %v\n", problem)
+				}
+			}
+
+			if invalidCount > 0 {
+				fmt.Printf("%d out of %d doujins are invalid\n", invalidCount, len(doujinPaths))
+				os.Exit(1)
+			}
+
+			os.Exit(0)
+
+		case "find-duplicates":
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			hashResult, err := db.HashUnhashedPages(newProgressCallback("Hashing"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to hash pages: %v\n", err)
+				os.Exit(1)
+			}
+
+			for _, failure := range hashResult.Failures {
+				fmt.Fprintf(os.Stderr, "WARNING: failed to hash page `%s`: %s\n", failure.Path, failure.Error)
+			}
+
+			groups, err := db.FindDuplicateDoujins()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to find duplicates: %v\n", err)
+				os.Exit(1)
+			}
+
+			for i, group := range groups {
+				if i > 0 {
+					fmt.Println()
+				}
+
+				fmt.Printf("Group %d (%d doujins):\n", i+1, len(group))
+				for _, doujin := range group {
+					fmt.Printf("    %d: %s (%d pages)\n", doujin.Id, doujin.Title, doujin.Pages)
+				}
+			}
+
+			if len(groups) == 0 {
+				fmt.Println("No duplicates found")
+			}
+
+			os.Exit(0)
+
+		case "find-similar":
+			serverConfig := LoadServerConfig()
+			maxDistance := serverConfig.SimilarityMaxDistance
+			switch flag := popArg(); flag {
+			case "":
+			case "--distance":
+				distance, err := strconv.Atoi(popArg())
+				if err != nil || distance < 0 || distance > 64 {
+					fmt.Fprintf(os.Stderr, "ERROR: --distance expects a number between 0 and 64\n")
+					manageUsage(os.Stderr, programName)
+					os.Exit(1)
+				}
+				maxDistance = distance
+			default:
+				fmt.Fprintf(os.Stderr, "ERROR: unknown flag `%s`\n", flag)
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			db, err := NewDatabase(serverConfig)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			hashResult, err := db.ComputeMissingPerceptualHashes(newProgressCallback("Computing perceptual hashes of"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to hash pages: %v\n", err)
+				os.Exit(1)
+			}
+
+			for _, failure := range hashResult.Failures {
+				fmt.Fprintf(os.Stderr, "WARNING: failed to hash page `%s`: %s\n", failure.Path, failure.Error)
+			}
+
+			groups, err := db.FindSimilarDoujins(maxDistance)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to find similar doujins: %v\n", err)
+				os.Exit(1)
+			}
+
+			for i, group := range groups {
+				if i > 0 {
+					fmt.Println()
+				}
+
+				fmt.Printf("Group %d (%d doujins):\n", i+1, len(group))
+				for _, doujin := range group {
+					fmt.Printf("    %d: %s (%d pages)\n", doujin.Id, doujin.Title, doujin.Pages)
+				}
+			}
+
+			if len(groups) == 0 {
+				fmt.Println("No similar doujins found")
+			}
+
+			os.Exit(0)
+
+		case "backfill-page-info":
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			result, err := db.ComputeMissingPageInfo(newProgressCallback("Reading"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to read pages: %v\n", err)
+				os.Exit(1)
+			}
+
+			for _, failure := range result.Failures {
+				fmt.Fprintf(os.Stderr, "WARNING: failed to read page `%s`: %s\n", failure.Path, failure.Error)
+			}
+
+			fmt.Printf("Recorded the info of %d pages\n", result.Updated)
+			if len(result.Failures) > 0 {
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		case "generate-thumbnails":
+			allPages := false
+			switch flag := popArg(); flag {
+			case "":
+			case "--all-pages":
+				allPages = true
+			default:
+				fmt.Fprintf(os.Stderr, "ERROR: unknown flag `%s`\n", flag)
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			result, err := db.GenerateThumbnails(allPages, newProgressCallback("Generating thumbnails of"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to generate thumbnails: %v\n", err)
+				os.Exit(1)
+			}
+
+			for _, failure := range result.Failures {
+				fmt.Fprintf(os.Stderr, "WARNING: failed to generate thumbnail of page `%s`: %s\n", failure.Path, failure.Error)
+			}
+
+			fmt.Printf("Generated %d thumbnails, %d were already up to date\n", result.Generated, result.Cached)
+			if result.Unsupported > 0 {
+				fmt.Printf("%d pages are in formats that can't be decoded and are served as they are\n", result.Unsupported)
+			}
+			if len(result.Failures) > 0 {
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		case "fsck":
+			options := FsckOptions{}
+			for flag := popArg(); flag != ""; flag = popArg() {
+				switch flag {
+				case "--repair":
+					options.Repair = true
+				case "--new-root":
+					newRoot := popArg()
+					if newRoot == "" {
+						fmt.Fprintf(os.Stderr, "ERROR: --new-root expects a directory\n")
+						manageUsage(os.Stderr, programName)
+						os.Exit(1)
+					}
+
+					absoluteRoot, err := filepath.Abs(newRoot)
+					if err != nil {
+						fmt.Fprintf(os.Stderr, "ERROR: failed to get absolute path of `%s`: %v\n", newRoot, err)
+						os.Exit(1)
+					}
+					options.NewRoot = absoluteRoot
+				default:
+					fmt.Fprintf(os.Stderr, "ERROR: unknown flag `%s`\n", flag)
+					manageUsage(os.Stderr, programName)
+					os.Exit(1)
+				}
+			}
+
+			if options.NewRoot != "" && !options.Repair {
+				fmt.Fprintf(os.Stderr, "ERROR: --new-root can only be used with --repair\n")
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			report, err := db.Fsck(options, newProgressCallback("Checking"))
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to check the database: %v\n", err)
+				os.Exit(1)
+			}
+
+			for _, problem := range report.Problems {
+				status := ""
+				if problem.Repaired {
+					status = " [repaired]"
+				}
+				fmt.Printf("Doujin %d: %s%s\n", problem.DoujinId, problem.Description, status)
+			}
+
+			unrepaired := report.Unrepaired()
+			fmt.Printf("Checked %d doujins and %d pages: %d problems found, %d repaired\n",
+				report.CheckedDoujins, report.CheckedPages, len(report.Problems), len(report.Problems)-unrepaired)
+
+			if unrepaired > 0 {
+				os.Exit(1)
+			}
+			os.Exit(0)
+
+		case "relocate":
+			apply := false
+			prefixes := []string{}
+			for arg := popArg(); arg != ""; arg = popArg() {
+				if arg == "--apply" {
+					apply = true
+					continue
+				}
+
+				prefix, err := filepath.Abs(arg)
+				if err != nil {
+					fmt.Fprintf(os.Stderr, "ERROR: failed to get absolute path of `%s`: %v\n", arg, err)
+					os.Exit(1)
+				}
+				prefixes = append(prefixes, prefix)
+			}
+
+			if len(prefixes) != 2 {
+				fmt.Fprintf(os.Stderr, "ERROR: expected an old and a new prefix\n")
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			result, err := db.RelocatePages(prefixes[0], prefixes[1], !apply)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to relocate pages: %v\n", err)
+				os.Exit(1)
+			}
+
+			if len(result.MissingFiles) > 0 {
+				for _, missingFile := range result.MissingFiles {
+					fmt.Fprintf(os.Stderr, "ERROR: `%s` does not exist\n", missingFile)
+				}
+				fmt.Fprintf(os.Stderr, "ERROR: %d of %d pages would not exist after relocating; nothing was changed\n",
+					len(result.MissingFiles), result.Pages)
+				os.Exit(1)
+			}
+
+			if !result.Committed {
+				fmt.Printf("Would relocate %d pages and %d import log entries (run with --apply to relocate them)\n",
+					result.Pages, result.ImportLogEntries)
+				os.Exit(0)
+			}
+
+			fmt.Printf("Relocated %d pages and %d import log entries\n", result.Pages, result.ImportLogEntries)
+			os.Exit(0)
+
+		case "import-log":
+			onlyFailed := false
+			switch flag := popArg(); flag {
+			case "":
+			case "--failed":
+				onlyFailed = true
+			default:
+				fmt.Fprintf(os.Stderr, "ERROR: unknown flag `%s`\n", flag)
+				manageUsage(os.Stderr, programName)
+				os.Exit(1)
+			}
+
+			db, err := NewDatabase(LoadServerConfig())
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to create database: %v\n", err)
+				os.Exit(1)
+			}
+			defer db.Close()
+
+			entries, err := db.GetImportLog(onlyFailed)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "ERROR: failed to read import log: %v\n", err)
+				os.Exit(1)
+			}
+
+			for _, entry := range entries {
+				date := entry.Date.Format(time.DateTime)
+				if entry.Succeeded() {
+					if entry.DoujinId != 0 {
+						fmt.Printf("%s  OK      `%s` (doujin %d)\n", date, entry.SourcePath, entry.DoujinId)
+					} else {
+						fmt.Printf("%s  OK      `%s` (already imported)\n", date, entry.SourcePath)
+					}
+				} else {
+					fmt.Printf("%s  FAILED  `%s`: %s\n", date, entry.SourcePath, entry.Error)
+				}
+			}
+
 			os.Exit(0)
 
 		case "register-user":
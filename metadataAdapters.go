@@ -0,0 +1,546 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A MetadataAdapter converts the metadata files written by some tool into a
+// DoujinImportMetadata.
+type MetadataAdapter struct {
+	// Name used to choose the adapter with --metadata-format
+	Name string
+
+	// Extension of the files the format is written in
+	Extension string
+
+	// Reports whether a JSON file with these top-level fields is in this
+	// format. Only used for autodetection, so it can be nil for formats that
+	// are known by their extension.
+	Detect func(fields map[string]json.RawMessage) bool
+
+	// filePath is the file's path shown in errors
+	Decode func(data []byte, filePath string) (DoujinImportMetadata, error)
+}
+
+// Files the metadata of a doujin is read from, in order of preference.
+var metadataFileNames = []string{"metadata.json", "info.json", "ComicInfo.xml"}
+
+// Adapters are tried in order when autodetecting the format of a file, so
+// hv's own format, which detects anything, must be the last JSON one.
+var metadataAdapters = []MetadataAdapter{
+	{
+		Name:      "comicinfo",
+		Extension: ".xml",
+		Decode:    decodeComicInfoMetadata,
+	},
+	{
+		Name:      "gallery-dl",
+		Extension: ".json",
+		Detect: func(fields map[string]json.RawMessage) bool {
+			return hasJsonFields(fields, "category", "subcategory")
+		},
+		Decode: decodeGalleryDlMetadata,
+	},
+	{
+		Name:      "nhentai",
+		Extension: ".json",
+		Detect: func(fields map[string]json.RawMessage) bool {
+			return hasJsonFields(fields, "media_id", "num_pages")
+		},
+		Decode: decodeNhentaiMetadata,
+	},
+	{
+		Name:      "ehentai",
+		Extension: ".json",
+		Detect: func(fields map[string]json.RawMessage) bool {
+			return hasJsonFields(fields, "gmetadata") || hasJsonFields(fields, "gid", "token")
+		},
+		Decode: decodeEHentaiMetadata,
+	},
+	{
+		Name:      "hv",
+		Extension: ".json",
+		Detect: func(fields map[string]json.RawMessage) bool {
+			return true
+		},
+		Decode: decodeHvMetadata,
+	},
+}
+
+// Returns the adapter called name, or nil if there is none.
+func metadataAdapterByName(name string) *MetadataAdapter {
+	for i := range metadataAdapters {
+		if strings.EqualFold(metadataAdapters[i].Name, name) {
+			return &metadataAdapters[i]
+		}
+	}
+	return nil
+}
+
+func metadataAdapterNames() []string {
+	names := []string{}
+	for _, adapter := range metadataAdapters {
+		names = append(names, adapter.Name)
+	}
+	return names
+}
+
+func hasJsonFields(fields map[string]json.RawMessage, names ...string) bool {
+	for _, name := range names {
+		if _, ok := fields[name]; !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// Reads the doujin's metadata from the first of metadataFileNames it has. If
+// format is empty, it's detected from the file; otherwise only files the
+// adapter called format can read are considered.
+func readDoujinImportMetadata(source *doujinSource, format string) (DoujinImportMetadata, error) {
+	candidates := []string{}
+	for _, name := range metadataFileNames {
+		adapter := metadataAdapterByName(format)
+		if adapter != nil && !strings.EqualFold(path.Ext(name), adapter.Extension) {
+			continue
+		}
+		candidates = append(candidates, name)
+	}
+
+	for _, name := range candidates {
+		if _, err := fs.Stat(source.fsys, name); err == nil {
+			return readMetadataFile(source.fsys, name, source.PageFile(name).String(), format)
+		}
+	}
+
+	// Reports the first candidate as missing
+	return readMetadataFile(source.fsys, candidates[0], source.PageFile(candidates[0]).String(), format)
+}
+
+// Reads the metadata file `name` in fsys with the adapter called format, or
+// with the one detected from the file's extension and content if format is
+// empty. filePath is the file's path shown in errors.
+func readMetadataFile(fsys fs.FS, name string, filePath string, format string) (DoujinImportMetadata, error) {
+	adapter := metadataAdapterByName(format)
+	if format != "" && adapter == nil {
+		return DoujinImportMetadata{}, fmt.Errorf("Unknown metadata format `%s` (expected one of %s)", format, strings.Join(metadataAdapterNames(), ", "))
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return DoujinImportMetadata{}, fmt.Errorf("Failed to open file `%s`: %w", filePath, err)
+	}
+	defer file.Close()
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return DoujinImportMetadata{}, fmt.Errorf("Failed to read file `%s`: %w", filePath, err)
+	}
+
+	if adapter == nil {
+		adapter, err = detectMetadataFormat(name, data, filePath)
+		if err != nil {
+			return DoujinImportMetadata{}, err
+		}
+	}
+
+	return adapter.Decode(data, filePath)
+}
+
+func detectMetadataFormat(name string, data []byte, filePath string) (*MetadataAdapter, error) {
+	extension := strings.ToLower(path.Ext(name))
+	if extension != ".xml" {
+		extension = ".json"
+	}
+
+	var fields map[string]json.RawMessage
+	if extension == ".json" {
+		err := json.Unmarshal(data, &fields)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to decode JSON file `%s`: %w", filePath, err)
+		}
+	}
+
+	for i, adapter := range metadataAdapters {
+		if adapter.Extension != extension {
+			continue
+		}
+
+		if adapter.Detect == nil || adapter.Detect(fields) {
+			return &metadataAdapters[i], nil
+		}
+	}
+
+	return nil, fmt.Errorf("The format of `%s` is unknown", filePath)
+}
+
+func decodeJsonMetadata(data []byte, filePath string, v any) error {
+	err := json.Unmarshal(data, v)
+	if err != nil {
+		return fmt.Errorf("Failed to decode JSON file `%s`: %w", filePath, err)
+	}
+	return nil
+}
+
+func decodeComicInfoMetadata(data []byte, filePath string) (DoujinImportMetadata, error) {
+	doujinMeta, err := DecodeComicInfo(bytes.NewReader(data))
+	if err != nil {
+		return DoujinImportMetadata{}, fmt.Errorf("Failed to decode XML file `%s`: %w", filePath, err)
+	}
+	return doujinMeta, nil
+}
+
+func decodeHvMetadata(data []byte, filePath string) (DoujinImportMetadata, error) {
+	var doujinMeta DoujinImportMetadata
+	err := decodeJsonMetadata(data, filePath, &doujinMeta)
+	if err != nil {
+		return DoujinImportMetadata{}, err
+	}
+
+	// None of the fields are optional
+	var fields map[string]json.RawMessage
+	err = decodeJsonMetadata(data, filePath, &fields)
+	if err != nil {
+		return DoujinImportMetadata{}, err
+	}
+
+	problems := []error{}
+	for _, requiredField := range []string{
+		"title", "subtitle", "favorite_counts", "upload_date", "character",
+		"tag", "artist", "group", "language", "pages",
+	} {
+		found := false
+		for field := range fields {
+			if strings.EqualFold(field, requiredField) {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			problems = append(problems, fmt.Errorf("Field `%s` is missing from `%s`", requiredField, filePath))
+		}
+	}
+
+	if len(problems) > 0 {
+		return doujinMeta, &ValidationError{problems}
+	}
+
+	return doujinMeta, nil
+}
+
+// A number that may also be written as a JSON string, like most numbers in
+// the E-Hentai API. Fractions are truncated.
+type flexibleInt int
+
+func (n *flexibleInt) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "" || text == "null" {
+		*n = 0
+		return nil
+	}
+
+	value, err := strconv.ParseFloat(text, 64)
+	if err != nil {
+		return fmt.Errorf("`%s` is not a number", text)
+	}
+
+	*n = flexibleInt(value)
+	return nil
+}
+
+// A date written either as a Unix timestamp, as a number or a string, or as
+// text in one of a few common layouts. Dates without a time zone are UTC.
+type flexibleTime time.Time
+
+var flexibleTimeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02",
+}
+
+func (t *flexibleTime) UnmarshalJSON(data []byte) error {
+	text := strings.Trim(string(data), `"`)
+	if text == "" || text == "null" {
+		*t = flexibleTime{}
+		return nil
+	}
+
+	if seconds, err := strconv.ParseInt(text, 10, 64); err == nil {
+		*t = flexibleTime(time.Unix(seconds, 0).UTC())
+		return nil
+	}
+
+	for _, layout := range flexibleTimeLayouts {
+		value, err := time.Parse(layout, text)
+		if err == nil {
+			*t = flexibleTime(value)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("`%s` is not a valid date", text)
+}
+
+// A list of strings that may also be written as a single string.
+type flexibleStrings []string
+
+func (values *flexibleStrings) UnmarshalJSON(data []byte) error {
+	var value string
+	if json.Unmarshal(data, &value) == nil {
+		*values = nil
+		if value != "" {
+			*values = []string{value}
+		}
+		return nil
+	}
+
+	return json.Unmarshal(data, (*[]string)(values))
+}
+
+// Namespaces used by E-Hentai's tags, which nhentai's tag types are a subset
+// of.
+var tagNamespaces = []string{
+	"artist", "group", "character", "parody", "language", "category",
+	"female", "male", "mixed", "other", "cosplayer", "reclass", "temp", "tag",
+}
+
+func appendUnique(values []string, value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" || slices.Contains(values, value) {
+		return values
+	}
+	return append(values, value)
+}
+
+// Puts a tag of the given namespace in the right field of doujinMeta. Parodies
+// and tags of namespaces without their own field are kept as plain tags, and
+// categories, like "doujinshi", are dropped.
+func addNamespacedTag(doujinMeta *DoujinImportMetadata, namespace string, name string) {
+	switch strings.ToLower(namespace) {
+	case "artist":
+		doujinMeta.Artists = appendUnique(doujinMeta.Artists, name)
+	case "group":
+		doujinMeta.Groups = appendUnique(doujinMeta.Groups, name)
+	case "character":
+		doujinMeta.Characters = appendUnique(doujinMeta.Characters, name)
+	case "language":
+		// Not languages, but whether the doujin was translated
+		if strings.EqualFold(name, "translated") || strings.EqualFold(name, "rewrite") {
+			doujinMeta.Tags = appendUnique(doujinMeta.Tags, strings.ToLower(name))
+		} else {
+			doujinMeta.Languages = appendUnique(doujinMeta.Languages, strings.ToLower(name))
+		}
+	case "category":
+	default:
+		doujinMeta.Tags = appendUnique(doujinMeta.Tags, name)
+	}
+}
+
+// Like addNamespacedTag, for tags written as "namespace:name". Tags without a
+// known namespace are kept as plain tags.
+func addPrefixedTag(doujinMeta *DoujinImportMetadata, tag string) {
+	namespace, name, found := strings.Cut(tag, ":")
+	if !found || !slices.Contains(tagNamespaces, strings.ToLower(namespace)) {
+		addNamespacedTag(doujinMeta, "tag", tag)
+		return
+	}
+	addNamespacedTag(doujinMeta, namespace, name)
+}
+
+func emptyImportMetadata() DoujinImportMetadata {
+	return DoujinImportMetadata{
+		Characters: []string{},
+		Tags:       []string{},
+		Artists:    []string{},
+		Groups:     []string{},
+		Languages:  []string{},
+	}
+}
+
+// The romanized title becomes the title, and the original one the subtitle.
+func setTitles(doujinMeta *DoujinImportMetadata, title string, originalTitle string) {
+	if title == "" {
+		title = originalTitle
+	}
+	if originalTitle == title {
+		originalTitle = ""
+	}
+
+	doujinMeta.Title = title
+	doujinMeta.Subtitle = originalTitle
+}
+
+// The info.json file written by gallery-dl with --write-info-json. Which
+// fields are present depends on the site it was downloaded from.
+type galleryDlInfo struct {
+	Title      string          `json:"title"`
+	TitleEn    string          `json:"title_en"`
+	TitleJa    string          `json:"title_ja"`
+	TitleJpn   string          `json:"title_jpn"`
+	Date       flexibleTime    `json:"date"`
+	Count      flexibleInt     `json:"count"`
+	FileCount  flexibleInt     `json:"filecount"`
+	Favorites  flexibleInt     `json:"favorites"`
+	Tags       flexibleStrings `json:"tags"`
+	Artists    flexibleStrings `json:"artist"`
+	Groups     flexibleStrings `json:"group"`
+	Characters flexibleStrings `json:"characters"`
+	Parodies   flexibleStrings `json:"parody"`
+	Language   flexibleStrings `json:"language"`
+	Lang       string          `json:"lang"`
+}
+
+func decodeGalleryDlMetadata(data []byte, filePath string) (DoujinImportMetadata, error) {
+	var info galleryDlInfo
+	err := decodeJsonMetadata(data, filePath, &info)
+	if err != nil {
+		return DoujinImportMetadata{}, err
+	}
+
+	doujinMeta := emptyImportMetadata()
+
+	title := info.TitleEn
+	if title == "" {
+		title = info.Title
+	}
+	originalTitle := info.TitleJa
+	if originalTitle == "" {
+		originalTitle = info.TitleJpn
+	}
+	setTitles(&doujinMeta, title, originalTitle)
+
+	doujinMeta.ExternalRating = int(info.Favorites)
+	doujinMeta.UploadDate = time.Time(info.Date)
+
+	doujinMeta.Pages = int(info.Count)
+	if doujinMeta.Pages == 0 {
+		doujinMeta.Pages = int(info.FileCount)
+	}
+
+	for _, tag := range info.Tags {
+		addPrefixedTag(&doujinMeta, tag)
+	}
+	for _, artist := range info.Artists {
+		addNamespacedTag(&doujinMeta, "artist", artist)
+	}
+	for _, group := range info.Groups {
+		addNamespacedTag(&doujinMeta, "group", group)
+	}
+	for _, character := range info.Characters {
+		addNamespacedTag(&doujinMeta, "character", character)
+	}
+	for _, parody := range info.Parodies {
+		addNamespacedTag(&doujinMeta, "parody", parody)
+	}
+	for _, language := range info.Language {
+		addNamespacedTag(&doujinMeta, "language", language)
+	}
+	if len(doujinMeta.Languages) == 0 && info.Lang != "" {
+		doujinMeta.Languages = comicInfoLanguage(info.Lang)
+	}
+
+	return doujinMeta, nil
+}
+
+// A gallery as returned by nhentai's /api/gallery/ID endpoint.
+type nhentaiGallery struct {
+	Title struct {
+		English  string `json:"english"`
+		Japanese string `json:"japanese"`
+		Pretty   string `json:"pretty"`
+	} `json:"title"`
+	UploadDate   flexibleTime `json:"upload_date"`
+	NumPages     flexibleInt  `json:"num_pages"`
+	NumFavorites flexibleInt  `json:"num_favorites"`
+	Tags         []struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	} `json:"tags"`
+}
+
+func decodeNhentaiMetadata(data []byte, filePath string) (DoujinImportMetadata, error) {
+	var gallery nhentaiGallery
+	err := decodeJsonMetadata(data, filePath, &gallery)
+	if err != nil {
+		return DoujinImportMetadata{}, err
+	}
+
+	doujinMeta := emptyImportMetadata()
+
+	title := gallery.Title.English
+	if title == "" {
+		title = gallery.Title.Pretty
+	}
+	setTitles(&doujinMeta, title, gallery.Title.Japanese)
+
+	doujinMeta.ExternalRating = int(gallery.NumFavorites)
+	doujinMeta.UploadDate = time.Time(gallery.UploadDate)
+	doujinMeta.Pages = int(gallery.NumPages)
+
+	for _, tag := range gallery.Tags {
+		addNamespacedTag(&doujinMeta, tag.Type, tag.Name)
+	}
+
+	return doujinMeta, nil
+}
+
+// A gallery as returned by the gdata method of the E-Hentai API.
+type eHentaiGallery struct {
+	Error     string       `json:"error"`
+	Title     string       `json:"title"`
+	TitleJpn  string       `json:"title_jpn"`
+	Posted    flexibleTime `json:"posted"`
+	FileCount flexibleInt  `json:"filecount"`
+	Tags      []string     `json:"tags"`
+}
+
+// Accepts both a whole API response, which must hold a single gallery, and
+// the gallery alone.
+func decodeEHentaiMetadata(data []byte, filePath string) (DoujinImportMetadata, error) {
+	var response struct {
+		GMetadata []json.RawMessage `json:"gmetadata"`
+	}
+	err := decodeJsonMetadata(data, filePath, &response)
+	if err != nil {
+		return DoujinImportMetadata{}, err
+	}
+
+	if response.GMetadata != nil {
+		if len(response.GMetadata) != 1 {
+			return DoujinImportMetadata{}, fmt.Errorf("`%s` must contain exactly one gallery (found %d)", filePath, len(response.GMetadata))
+		}
+		data = response.GMetadata[0]
+	}
+
+	var gallery eHentaiGallery
+	err = decodeJsonMetadata(data, filePath, &gallery)
+	if err != nil {
+		return DoujinImportMetadata{}, err
+	}
+
+	if gallery.Error != "" {
+		return DoujinImportMetadata{}, fmt.Errorf("`%s` contains an error instead of a gallery: %s", filePath, gallery.Error)
+	}
+
+	doujinMeta := emptyImportMetadata()
+	setTitles(&doujinMeta, gallery.Title, gallery.TitleJpn)
+	doujinMeta.UploadDate = time.Time(gallery.Posted)
+	doujinMeta.Pages = int(gallery.FileCount)
+
+	for _, tag := range gallery.Tags {
+		addPrefixedTag(&doujinMeta, tag)
+	}
+
+	return doujinMeta, nil
+}
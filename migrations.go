@@ -0,0 +1,258 @@
+package main
+
+import (
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+type schemaMigration struct {
+	from    string
+	to      string
+	migrate func(tx *sql.Tx) error
+}
+
+var schemaMigrations = []schemaMigration{
+	{"v1", "v2", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE DoujinPages ADD COLUMN archive_entry TEXT NOT NULL DEFAULT ''`)
+		return err
+	}},
+	{"v2", "v3", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`CREATE TABLE ImportLog (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			source_path TEXT NOT NULL UNIQUE,
+			signature TEXT NOT NULL,
+			doujin_id INTEGER,
+			error TEXT NOT NULL,
+			date TEXT NOT NULL
+		)`)
+		return err
+	}},
+	{"v3", "v4", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			ALTER TABLE DoujinPages ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';
+			ALTER TABLE Doujins ADD COLUMN content_hash TEXT NOT NULL DEFAULT '';
+			CREATE INDEX DoujinPages_content_hash ON DoujinPages (content_hash);
+			CREATE INDEX Doujins_content_hash ON Doujins (content_hash);
+		`)
+		return err
+	}},
+	{"v4", "v5", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE DoujinPages ADD COLUMN perceptual_hash INTEGER`)
+		return err
+	}},
+	{"v5", "v6", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`ALTER TABLE Doujins ADD COLUMN unavailable INTEGER NOT NULL DEFAULT 0`)
+		return err
+	}},
+	{"v6", "v7", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			ALTER TABLE DoujinPages ADD COLUMN width INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE DoujinPages ADD COLUMN height INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE DoujinPages ADD COLUMN size INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE DoujinPages ADD COLUMN mime_type TEXT NOT NULL DEFAULT '';
+		`)
+		return err
+	}},
+	{"v7", "v8", func(tx *sql.Tx) error {
+		// Pages imported before this are left without a name, as the
+		// files of pages in the library are named after their content
+		_, err := tx.Exec(`ALTER TABLE DoujinPages ADD COLUMN original_name TEXT NOT NULL DEFAULT ''`)
+		return err
+	}},
+	{"v8", "v9", func(tx *sql.Tx) error {
+		_, err := tx.Exec(`
+			CREATE TABLE Series (
+				id INTEGER PRIMARY KEY AUTOINCREMENT,
+				title TEXT NOT NULL
+			);
+			ALTER TABLE Doujins ADD COLUMN series_id INTEGER REFERENCES Series(id) ON DELETE SET NULL;
+			ALTER TABLE Doujins ADD COLUMN chapter_number INTEGER NOT NULL DEFAULT 0;
+			CREATE INDEX Doujins_series ON Doujins (series_id, chapter_number);
+		`)
+		return err
+	}},
+	{"v9", "v10", func(tx *sql.Tx) error {
+		// The list columns are indexed as the JSON arrays they're stored as,
+		// which the tokenizer splits into their values
+		_, err := tx.Exec(`
+			CREATE VIRTUAL TABLE DoujinsSearch USING fts5 (
+				title, subtitle, artists, groups, characters, tags,
+				content = 'Doujins',
+				content_rowid = 'id',
+				tokenize = 'unicode61 remove_diacritics 2'
+			);
+
+			CREATE TRIGGER Doujins_search_insert AFTER INSERT ON Doujins BEGIN
+				INSERT INTO DoujinsSearch (rowid, title, subtitle, artists, groups, characters, tags)
+				VALUES (new.id, new.title, new.subtitle, new.artists, new.groups, new.characters, new.tags);
+			END;
+
+			CREATE TRIGGER Doujins_search_delete AFTER DELETE ON Doujins BEGIN
+				INSERT INTO DoujinsSearch (DoujinsSearch, rowid, title, subtitle, artists, groups, characters, tags)
+				VALUES ('delete', old.id, old.title, old.subtitle, old.artists, old.groups, old.characters, old.tags);
+			END;
+
+			CREATE TRIGGER Doujins_search_update AFTER UPDATE OF title, subtitle, artists, groups, characters, tags ON Doujins BEGIN
+				INSERT INTO DoujinsSearch (DoujinsSearch, rowid, title, subtitle, artists, groups, characters, tags)
+				VALUES ('delete', old.id, old.title, old.subtitle, old.artists, old.groups, old.characters, old.tags);
+				INSERT INTO DoujinsSearch (rowid, title, subtitle, artists, groups, characters, tags)
+				VALUES (new.id, new.title, new.subtitle, new.artists, new.groups, new.characters, new.tags);
+			END;
+
+			INSERT INTO DoujinsSearch (DoujinsSearch) VALUES ('rebuild');
+		`)
+		return err
+	}},
+	{"v10", "v11", func(tx *sql.Tx) error {
+		// Indexes for the sort orders of searches; sorting by import date
+		// uses the primary key
+		_, err := tx.Exec(`
+			CREATE INDEX Doujins_upload_date ON Doujins (upload_date);
+			CREATE INDEX Doujins_title ON Doujins (title COLLATE NOCASE);
+			CREATE INDEX Doujins_external_rating ON Doujins (external_rating);
+			CREATE INDEX Doujins_pages ON Doujins (pages);
+		`)
+		return err
+	}},
+	{"v11", "v12", func(tx *sql.Tx) error {
+		// Upload dates were stored with the offset they were imported with,
+		// so they didn't sort in chronological order
+		rows, err := tx.Query(`SELECT id, upload_date FROM Doujins`)
+		if err != nil {
+			return err
+		}
+
+		uploadDates := map[int]string{}
+		for rows.Next() {
+			var id int
+			var uploadDate string
+			err = rows.Scan(&id, &uploadDate)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+
+			// Dates that can't be parsed are left as they are
+			parsedDate, err := time.Parse(time.RFC3339, uploadDate)
+			if err == nil && formatUploadDate(parsedDate) != uploadDate {
+				uploadDates[id] = formatUploadDate(parsedDate)
+			}
+		}
+
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		for id, uploadDate := range uploadDates {
+			_, err = tx.Exec(`UPDATE Doujins SET upload_date = ? WHERE id = ?`, uploadDate, id)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}},
+	{"v12", "v13", func(tx *sql.Tx) error {
+		// Doujins were only logged when imported by the directory watcher,
+		// so the sources of the rest are logged from the paths of their first
+		// pages. Pages in the library, which are named after their content,
+		// don't tell where they were imported from, so they're left out.
+		rows, err := tx.Query(`
+			SELECT doujin_id, page_path, archive_entry, original_name, content_hash
+			FROM DoujinPages WHERE page_number = 1
+		`)
+		if err != nil {
+			return err
+		}
+
+		sourcePaths := map[string]int{}
+		for rows.Next() {
+			var doujinId int
+			var page PageFile
+			var originalName, contentHash string
+			err = rows.Scan(&doujinId, &page.Path, &page.ArchiveEntry, &originalName, &contentHash)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+
+			fileName := filepath.Base(page.Path)
+			switch {
+			case page.ArchiveEntry != "":
+				sourcePaths[page.Path] = doujinId
+			case contentHash != "" && strings.TrimSuffix(fileName, filepath.Ext(fileName)) == contentHash:
+				continue
+			case originalName != "" && strings.HasSuffix(page.Path, "/"+originalName):
+				// Pages can be in subfolders of the doujin's folder
+				sourcePaths[strings.TrimSuffix(page.Path, "/"+originalName)] = doujinId
+			default:
+				// Pages imported before v8 have no name, but they were
+				// always directly in the doujin's folder
+				sourcePaths[filepath.Dir(page.Path)] = doujinId
+			}
+		}
+
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return err
+		}
+
+		date := time.Now().Format(time.RFC3339)
+		for sourcePath, doujinId := range sourcePaths {
+			_, err = tx.Exec(
+				`INSERT OR IGNORE INTO ImportLog (source_path, signature, doujin_id, error, date) VALUES (?, '', ?, '', ?)`,
+				sourcePath, doujinId, date,
+			)
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}},
+}
+
+func latestSchemaVersion() string {
+	return schemaMigrations[len(schemaMigrations)-1].to
+}
+
+func migrateSchema(db *sql.DB, schemaVersion string) error {
+	for _, m := range schemaMigrations {
+		if m.from != schemaVersion {
+			continue
+		}
+
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		err = m.migrate(tx)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		_, err = tx.Exec(`UPDATE "META" SET schema_version = ?`, m.to)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		err = tx.Commit()
+		if err != nil {
+			return err
+		}
+
+		schemaVersion = m.to
+	}
+
+	if schemaVersion != latestSchemaVersion() {
+		return DatabaseErrorInvalidSchema
+	}
+
+	return nil
+}
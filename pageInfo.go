@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"image"
+	"io"
+	"io/fs"
+)
+
+// What's known about the image file of a page. Width and Height are 0 when
+// the image's header couldn't be decoded.
+type PageInfo struct {
+	Width    int
+	Height   int
+	Size     int64
+	MimeType string
+}
+
+// Number of bytes needed by webpDimensions.
+const WebpHeaderLength = 30
+
+// The standard library can't decode WebP images, so their dimensions are read
+// from the header of their first chunk.
+func webpDimensions(header []byte) (int, int, bool) {
+	if len(header) < WebpHeaderLength {
+		return 0, 0, false
+	}
+
+	switch string(header[12:16]) {
+	case "VP8 ":
+		// Lossy: a frame tag and a start code, followed by 14 bit dimensions
+		if !bytes.Equal(header[23:26], []byte{0x9D, 0x01, 0x2A}) {
+			return 0, 0, false
+		}
+		width := int(binary.LittleEndian.Uint16(header[26:28]) & 0x3FFF)
+		height := int(binary.LittleEndian.Uint16(header[28:30]) & 0x3FFF)
+		return width, height, true
+
+	case "VP8L":
+		// Lossless: a signature byte, followed by 14 bit dimensions minus one
+		if header[20] != 0x2F {
+			return 0, 0, false
+		}
+		bits := binary.LittleEndian.Uint32(header[21:25])
+		return int(bits&0x3FFF) + 1, int((bits>>14)&0x3FFF) + 1, true
+
+	case "VP8X":
+		// Extended: 24 bit canvas dimensions minus one
+		width := int(header[24]) | int(header[25])<<8 | int(header[26])<<16
+		height := int(header[27]) | int(header[28])<<8 | int(header[29])<<16
+		return width + 1, height + 1, true
+
+	default:
+		return 0, 0, false
+	}
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+// Reads the whole image in r, returning its info. Only errors reading r are
+// returned; images with an unknown format or a broken header just get a
+// partial PageInfo.
+func readPageInfo(r io.Reader) (PageInfo, error) {
+	counter := &countingReader{r: r}
+
+	header := make([]byte, WebpHeaderLength)
+	n, err := io.ReadFull(counter, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return PageInfo{}, err
+	}
+	header = header[:n]
+
+	info := PageInfo{MimeType: imageTypeFromMagic(header)}
+	switch info.MimeType {
+	case "":
+	case "image/webp":
+		info.Width, info.Height, _ = webpDimensions(header)
+	default:
+		config, _, err := image.DecodeConfig(io.MultiReader(bytes.NewReader(header), counter))
+		if err == nil {
+			info.Width = config.Width
+			info.Height = config.Height
+		}
+	}
+
+	_, err = io.Copy(io.Discard, counter)
+	if err != nil {
+		return PageInfo{}, err
+	}
+	info.Size = counter.n
+
+	return info, nil
+}
+
+// Reads a file of a doujin being imported once, returning both its content
+// hash and its info.
+func inspectSourceFile(fsys fs.FS, name string) (string, PageInfo, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return "", PageInfo{}, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	info, err := readPageInfo(io.TeeReader(file, hash))
+	if err != nil {
+		return "", PageInfo{}, err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), info, nil
+}
+
+// Computes the info of pages imported before page info was stored.
+func (db *Database) ComputeMissingPageInfo(progress func(done int, total int)) (BackfillResult, error) {
+	rows, err := db.db.Query(`SELECT id, page_path, archive_entry FROM DoujinPages WHERE mime_type = ''`)
+	if err != nil {
+		return BackfillResult{}, err
+	}
+
+	pageIds := []int{}
+	pageFiles := []PageFile{}
+	for rows.Next() {
+		var pageId int
+		var pageFile PageFile
+		err = rows.Scan(&pageId, &pageFile.Path, &pageFile.ArchiveEntry)
+		if err != nil {
+			rows.Close()
+			return BackfillResult{}, err
+		}
+
+		pageIds = append(pageIds, pageId)
+		pageFiles = append(pageFiles, pageFile)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return BackfillResult{}, err
+	}
+
+	result := BackfillResult{Failures: []BulkImportFailure{}}
+	for i, pageFile := range pageFiles {
+		if progress != nil {
+			progress(i, len(pageFiles))
+		}
+
+		info, err := func() (PageInfo, error) {
+			file, err := pageFile.Open()
+			if err != nil {
+				return PageInfo{}, err
+			}
+			defer file.Close()
+
+			return readPageInfo(file)
+		}()
+		if err != nil {
+			result.Failures = append(result.Failures, BulkImportFailure{pageFile.String(), err.Error()})
+			continue
+		}
+
+		_, err = db.db.Exec(
+			`UPDATE DoujinPages SET width = ?, height = ?, size = ?, mime_type = ? WHERE id = ?`,
+			info.Width, info.Height, info.Size, info.MimeType, pageIds[i],
+		)
+		if err != nil {
+			return result, err
+		}
+		result.Updated++
+	}
+
+	if progress != nil {
+		progress(len(pageFiles), len(pageFiles))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math/bits"
+	"slices"
+)
+
+// Number of inner pages, besides the cover, that get a perceptual hash.
+const PerceptualHashInnerSamples = 4
+
+// Returns the numbers of the pages of a doujin with `pages` pages that get a
+// perceptual hash: the cover, followed by inner pages spread evenly across the
+// doujin.
+func perceptualSamplePages(pages int) []int {
+	if pages < 1 {
+		return []int{}
+	}
+
+	samples := []int{1}
+	for i := 1; i <= PerceptualHashInnerSamples; i++ {
+		pageNumber := 1 + pages*i/(PerceptualHashInnerSamples+1)
+		if pageNumber > 1 && pageNumber <= pages && !slices.Contains(samples, pageNumber) {
+			samples = append(samples, pageNumber)
+		}
+	}
+	return samples
+}
+
+// Computes the difference hash (dHash) of an image: the image is shrunk to
+// 9x8 grayscale pixels, and each bit tells whether a pixel is brighter than
+// the one to its right. Re-encoded, resized or slightly edited copies of an
+// image have hashes that differ in only a few bits.
+func differenceHash(img image.Image) uint64 {
+	const width = 9
+	const height = 8
+
+	bounds := img.Bounds()
+	var pixels [height][width]float64
+
+	for y := range height {
+		y0 := bounds.Min.Y + y*bounds.Dy()/height
+		y1 := max(bounds.Min.Y+(y+1)*bounds.Dy()/height, y0+1)
+		yStep := max((y1-y0)/16, 1)
+
+		for x := range width {
+			x0 := bounds.Min.X + x*bounds.Dx()/width
+			x1 := max(bounds.Min.X+(x+1)*bounds.Dx()/width, x0+1)
+			xStep := max((x1-x0)/16, 1)
+
+			// Average a grid of samples instead of every pixel of the block,
+			// as pages can be very large.
+			var sum float64
+			var count int
+			for sy := y0; sy < y1; sy += yStep {
+				for sx := x0; sx < x1; sx += xStep {
+					r, g, b, _ := img.At(sx, sy).RGBA()
+					sum += 0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b)
+					count++
+				}
+			}
+			pixels[y][x] = sum / float64(count)
+		}
+	}
+
+	var hash uint64
+	for y := range height {
+		for x := range width - 1 {
+			hash <<= 1
+			if pixels[y][x] > pixels[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+func perceptualHashReader(r io.Reader) (uint64, error) {
+	img, _, err := image.Decode(r)
+	if err != nil {
+		return 0, err
+	}
+	return differenceHash(img), nil
+}
+
+func hammingDistance(a uint64, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// Computes the missing perceptual hashes of the sampled pages of every doujin.
+// Pages that can't be decoded are skipped.
+func (db *Database) ComputeMissingPerceptualHashes(progress func(done int, total int)) (BackfillResult, error) {
+	rows, err := db.db.Query(`
+		SELECT DoujinPages.id, DoujinPages.page_path, DoujinPages.archive_entry, DoujinPages.page_number, Doujins.pages
+		FROM DoujinPages JOIN Doujins ON Doujins.id = DoujinPages.doujin_id
+		WHERE DoujinPages.perceptual_hash IS NULL
+	`)
+	if err != nil {
+		return BackfillResult{}, err
+	}
+
+	pageIds := []int{}
+	pageFiles := []PageFile{}
+	for rows.Next() {
+		var pageId int
+		var pageFile PageFile
+		var pageNumber int
+		var pages int
+		err = rows.Scan(&pageId, &pageFile.Path, &pageFile.ArchiveEntry, &pageNumber, &pages)
+		if err != nil {
+			rows.Close()
+			return BackfillResult{}, err
+		}
+
+		if !slices.Contains(perceptualSamplePages(pages), pageNumber) {
+			continue
+		}
+
+		pageIds = append(pageIds, pageId)
+		pageFiles = append(pageFiles, pageFile)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return BackfillResult{}, err
+	}
+
+	result := BackfillResult{Failures: []BulkImportFailure{}}
+	for i, pageFile := range pageFiles {
+		if progress != nil {
+			progress(i, len(pageFiles))
+		}
+
+		perceptualHash, err := func() (uint64, error) {
+			file, err := pageFile.Open()
+			if err != nil {
+				return 0, err
+			}
+			defer file.Close()
+
+			return perceptualHashReader(file)
+		}()
+		if err != nil {
+			result.Failures = append(result.Failures, BulkImportFailure{pageFile.String(), err.Error()})
+			continue
+		}
+
+		_, err = db.db.Exec(`UPDATE DoujinPages SET perceptual_hash = ? WHERE id = ?`, int64(perceptualHash), pageIds[i])
+		if err != nil {
+			return result, err
+		}
+		result.Updated++
+	}
+
+	if progress != nil {
+		progress(len(pageFiles), len(pageFiles))
+	}
+
+	return result, nil
+}
+
+type doujinPerceptualHashes struct {
+	doujinId int
+	cover    uint64
+	inner    []uint64
+}
+
+// Two doujins are similar if their covers are similar and at least half of
+// the sampled inner pages of one of them are similar to sampled inner pages of
+// the other.
+func (a doujinPerceptualHashes) isSimilarTo(b doujinPerceptualHashes, maxDistance int) bool {
+	if hammingDistance(a.cover, b.cover) > maxDistance {
+		return false
+	}
+
+	if len(a.inner) == 0 || len(b.inner) == 0 {
+		return true
+	}
+
+	if len(a.inner) > len(b.inner) {
+		a, b = b, a
+	}
+
+	matches := 0
+	for _, hashA := range a.inner {
+		for _, hashB := range b.inner {
+			if hammingDistance(hashA, hashB) <= maxDistance {
+				matches++
+				break
+			}
+		}
+	}
+
+	return matches*2 >= len(a.inner)
+}
+
+// Returns groups of doujins whose sampled pages have perceptual hashes within
+// maxDistance bits of each other.
+func (db *Database) FindSimilarDoujins(maxDistance int) ([][]DoujinListing, error) {
+	rows, err := db.db.Query(`
+		SELECT doujin_id, page_number, perceptual_hash FROM DoujinPages
+		WHERE perceptual_hash IS NOT NULL
+		ORDER BY doujin_id, page_number
+	`)
+	if err != nil {
+		return nil, err
+	}
+
+	doujins := []doujinPerceptualHashes{}
+	for rows.Next() {
+		var doujinId int
+		var pageNumber int
+		var perceptualHash int64
+		err = rows.Scan(&doujinId, &pageNumber, &perceptualHash)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if pageNumber == 1 {
+			doujins = append(doujins, doujinPerceptualHashes{doujinId, uint64(perceptualHash), []uint64{}})
+			continue
+		}
+
+		// Doujins without a hashed cover are left out
+		last := len(doujins) - 1
+		if last >= 0 && doujins[last].doujinId == doujinId {
+			doujins[last].inner = append(doujins[last].inner, uint64(perceptualHash))
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	// Union-find over every similar pair
+	parents := make([]int, len(doujins))
+	for i := range parents {
+		parents[i] = i
+	}
+
+	var find func(i int) int
+	find = func(i int) int {
+		if parents[i] != i {
+			parents[i] = find(parents[i])
+		}
+		return parents[i]
+	}
+
+	for i := range doujins {
+		for j := i + 1; j < len(doujins); j++ {
+			if find(i) == find(j) || !doujins[i].isSimilarTo(doujins[j], maxDistance) {
+				continue
+			}
+			parents[find(j)] = find(i)
+		}
+	}
+
+	groupIndexes := map[int]int{}
+	groupIds := [][]int{}
+	for i := range doujins {
+		root := find(i)
+		if _, ok := groupIndexes[root]; !ok {
+			groupIndexes[root] = len(groupIds)
+			groupIds = append(groupIds, []int{})
+		}
+		groupIds[groupIndexes[root]] = append(groupIds[groupIndexes[root]], doujins[i].doujinId)
+	}
+
+	// Listings of every grouped doujin, read with a single query
+	groupedIds := []int{}
+	for _, ids := range groupIds {
+		if len(ids) > 1 {
+			groupedIds = append(groupedIds, ids...)
+		}
+	}
+
+	listings := map[int]DoujinListing{}
+	if len(groupedIds) > 0 {
+		rows, err = db.db.Query(
+			`SELECT id, title, pages FROM Doujins WHERE id IN (SELECT value FROM json_each(?))`,
+			jsonEncode(groupedIds),
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		for rows.Next() {
+			var doujin DoujinListing
+			err = rows.Scan(&doujin.Id, &doujin.Title, &doujin.Pages)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			listings[doujin.Id] = doujin
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	groups := [][]DoujinListing{}
+	for _, ids := range groupIds {
+		group := []DoujinListing{}
+		for _, id := range ids {
+			if doujin, ok := listings[id]; ok {
+				group = append(group, doujin)
+			}
+		}
+
+		if len(group) > 1 {
+			groups = append(groups, group)
+		}
+	}
+
+	return groups, nil
+}
+
+// Comparing every pair of doujins is expensive, so only admins can do it.
+// A nil maxDistance means the configured default.
+func (db *Database) GetSimilarDoujins(username string, token string, maxDistance *int) ([][]DoujinListing, error) {
+	_, err := db.authenticateAdmin(username, token)
+	if err != nil {
+		return nil, err
+	}
+
+	if maxDistance == nil {
+		return db.FindSimilarDoujins(db.serverConfig.SimilarityMaxDistance)
+	}
+
+	if *maxDistance < 0 || *maxDistance > 64 {
+		return nil, DatabaseErrorInvalidDistance
+	}
+
+	return db.FindSimilarDoujins(*maxDistance)
+}
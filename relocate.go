@@ -0,0 +1,177 @@
+package main
+
+import (
+	"archive/zip"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type RelocateResult struct {
+	Pages            int
+	ImportLogEntries int
+
+	// Files that don't exist at their new location. Nothing is changed if
+	// there are any.
+	MissingFiles []string
+	Committed    bool
+}
+
+// Replaces the oldPrefix directory with newPrefix in the paths of every page
+// and import log entry under it. Nothing is changed if dryRun is true or if
+// any of the pages wouldn't exist at their new paths.
+func (db *Database) RelocatePages(oldPrefix string, newPrefix string, dryRun bool) (RelocateResult, error) {
+	oldPrefix = filepath.Clean(oldPrefix)
+	newPrefix = filepath.Clean(newPrefix)
+	if !filepath.IsAbs(oldPrefix) || !filepath.IsAbs(newPrefix) {
+		return RelocateResult{}, fmt.Errorf("Both prefixes must be absolute paths")
+	}
+
+	relocate := func(filePath string) string {
+		relativePath, err := filepath.Rel(oldPrefix, filePath)
+		if err != nil {
+			return filePath
+		}
+		return filepath.Join(newPrefix, relativePath)
+	}
+
+	// Only whole path components are replaced, so `/mnt/old` doesn't match
+	// `/mnt/older`.
+	childrenPattern := escapeSqlLike(strings.TrimSuffix(oldPrefix, string(filepath.Separator))+string(filepath.Separator)) + "%"
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return RelocateResult{}, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`SELECT id, page_path, archive_entry FROM DoujinPages WHERE page_path = ? OR page_path LIKE ? ESCAPE '\'`,
+		oldPrefix, childrenPattern,
+	)
+	if err != nil {
+		return RelocateResult{}, err
+	}
+
+	pageIds := []int{}
+	pageFiles := []PageFile{}
+	for rows.Next() {
+		var pageId int
+		var pageFile PageFile
+		err = rows.Scan(&pageId, &pageFile.Path, &pageFile.ArchiveEntry)
+		if err != nil {
+			rows.Close()
+			return RelocateResult{}, err
+		}
+
+		pageIds = append(pageIds, pageId)
+		pageFiles = append(pageFiles, PageFile{relocate(pageFile.Path), pageFile.ArchiveEntry})
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return RelocateResult{}, err
+	}
+
+	result := RelocateResult{
+		Pages:        len(pageIds),
+		MissingFiles: []string{},
+	}
+
+	// Pages of the same archive share it, so each archive is only opened once
+	archives := map[string]*zip.ReadCloser{}
+	defer func() {
+		for _, archive := range archives {
+			if archive != nil {
+				archive.Close()
+			}
+		}
+	}()
+
+	for _, pageFile := range pageFiles {
+		if pageFile.ArchiveEntry == "" {
+			if _, err := os.Stat(pageFile.Path); err != nil {
+				result.MissingFiles = append(result.MissingFiles, pageFile.String())
+			}
+			continue
+		}
+
+		archive, ok := archives[pageFile.Path]
+		if !ok {
+			archive, err = zip.OpenReader(pageFile.Path)
+			if err != nil {
+				archive = nil
+			}
+			archives[pageFile.Path] = archive
+		}
+
+		if archive == nil {
+			result.MissingFiles = append(result.MissingFiles, pageFile.String())
+			continue
+		}
+
+		if _, err := fs.Stat(archive, pageFile.ArchiveEntry); err != nil {
+			result.MissingFiles = append(result.MissingFiles, pageFile.String())
+		}
+	}
+
+	for i, pageId := range pageIds {
+		_, err = tx.Exec(`UPDATE DoujinPages SET page_path = ? WHERE id = ?`, pageFiles[i].Path, pageId)
+		if err != nil {
+			return result, err
+		}
+	}
+
+	rows, err = tx.Query(
+		`SELECT id, source_path FROM ImportLog WHERE source_path = ? OR source_path LIKE ? ESCAPE '\'`,
+		oldPrefix, childrenPattern,
+	)
+	if err != nil {
+		return result, err
+	}
+
+	importLogSources := map[int]string{}
+	for rows.Next() {
+		var entryId int
+		var sourcePath string
+		err = rows.Scan(&entryId, &sourcePath)
+		if err != nil {
+			rows.Close()
+			return result, err
+		}
+		importLogSources[entryId] = relocate(sourcePath)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return result, err
+	}
+
+	for entryId, sourcePath := range importLogSources {
+		// There may already be an entry at the new path, like when the
+		// watcher saw the files there before they were relocated. The
+		// relocated entry replaces it.
+		_, err = tx.Exec(`DELETE FROM ImportLog WHERE source_path = ? AND id != ?`, sourcePath, entryId)
+		if err != nil {
+			return result, err
+		}
+
+		_, err = tx.Exec(`UPDATE ImportLog SET source_path = ? WHERE id = ?`, sourcePath, entryId)
+		if err != nil {
+			return result, err
+		}
+	}
+	result.ImportLogEntries = len(importLogSources)
+
+	if dryRun || len(result.MissingFiles) > 0 {
+		return result, nil
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return result, err
+	}
+	result.Committed = true
+
+	return result, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+)
+
+// Returns the dimensions of an image of width x height pixels shrunk to fit in
+// maxWidth x maxHeight pixels, keeping its aspect ratio. Images are never
+// enlarged, and a maximum of 0 means no limit.
+func fitDimensions(width int, height int, maxWidth int, maxHeight int) (int, int) {
+	scale := 1.0
+	if maxWidth > 0 && width > maxWidth {
+		scale = min(scale, float64(maxWidth)/float64(width))
+	}
+	if maxHeight > 0 && height > maxHeight {
+		scale = min(scale, float64(maxHeight)/float64(height))
+	}
+
+	return max(int(math.Round(float64(width)*scale)), 1), max(int(math.Round(float64(height)*scale)), 1)
+}
+
+type areaContribution struct {
+	start   int
+	weights []float32
+}
+
+// Every destination pixel is the average of the source pixels it covers,
+// weighted by how much of each of them it covers.
+func areaContributions(sourceSize int, destinationSize int) []areaContribution {
+	scale := float64(sourceSize) / float64(destinationSize)
+	contributions := make([]areaContribution, destinationSize)
+
+	for i := range contributions {
+		low := float64(i) * scale
+		high := min(low+scale, float64(sourceSize))
+		start := int(low)
+		end := min(int(math.Ceil(high)), sourceSize)
+
+		weights := make([]float32, end-start)
+		for j := range weights {
+			pixelLow := max(low, float64(start+j))
+			pixelHigh := min(high, float64(start+j+1))
+			weights[j] = float32((pixelHigh - pixelLow) / (high - low))
+		}
+
+		contributions[i] = areaContribution{start, weights}
+	}
+
+	return contributions
+}
+
+// Shrinks img to width x height pixels by area averaging. The result is
+// premultiplied RGBA, like every *image.RGBA.
+func resizeImage(img image.Image, width int, height int) *image.RGBA {
+	bounds := img.Bounds()
+
+	source, ok := img.(*image.RGBA)
+	if !ok || bounds.Min != (image.Point{}) {
+		source = image.NewRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+		draw.Draw(source, source.Bounds(), img, bounds.Min, draw.Src)
+	}
+	sourceWidth := source.Rect.Dx()
+	sourceHeight := source.Rect.Dy()
+
+	// Resize horizontally, and then vertically
+	columns := areaContributions(sourceWidth, width)
+	rows := areaContributions(sourceHeight, height)
+
+	intermediate := make([]float32, width*sourceHeight*4)
+	for y := range sourceHeight {
+		sourceRow := source.Pix[y*source.Stride:]
+		intermediateRow := intermediate[y*width*4:]
+
+		for x, column := range columns {
+			var r, g, b, a float32
+			for i, weight := range column.weights {
+				pixel := sourceRow[(column.start+i)*4:]
+				r += float32(pixel[0]) * weight
+				g += float32(pixel[1]) * weight
+				b += float32(pixel[2]) * weight
+				a += float32(pixel[3]) * weight
+			}
+
+			intermediateRow[x*4+0] = r
+			intermediateRow[x*4+1] = g
+			intermediateRow[x*4+2] = b
+			intermediateRow[x*4+3] = a
+		}
+	}
+
+	destination := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y, row := range rows {
+		destinationRow := destination.Pix[y*destination.Stride:]
+
+		for x := range width {
+			var r, g, b, a float32
+			for i, weight := range row.weights {
+				pixel := intermediate[((row.start+i)*width+x)*4:]
+				r += pixel[0] * weight
+				g += pixel[1] * weight
+				b += pixel[2] * weight
+				a += pixel[3] * weight
+			}
+
+			destinationRow[x*4+0] = clampColor(r)
+			destinationRow[x*4+1] = clampColor(g)
+			destinationRow[x*4+2] = clampColor(b)
+			destinationRow[x*4+3] = clampColor(a)
+		}
+	}
+
+	return destination
+}
+
+func clampColor(value float32) uint8 {
+	return uint8(min(max(value+0.5, 0), 255))
+}
+
+// JPEG has no transparency, so transparent pixels are put over a white
+// background instead of becoming black.
+func flattenImage(img *image.RGBA) {
+	for i := 0; i < len(img.Pix); i += 4 {
+		transparency := 255 - img.Pix[i+3]
+		img.Pix[i+0] += transparency
+		img.Pix[i+1] += transparency
+		img.Pix[i+2] += transparency
+		img.Pix[i+3] = 255
+	}
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"log"
+	"os"
+)
+
+const DefaultResizeJpegQuality = 85
+
+// How a page should be resized before being sent. Zero values mean no limit
+// and the default quality.
+type ResizeOptions struct {
+	MaxWidth  int
+	MaxHeight int
+	Quality   int
+}
+
+func (options ResizeOptions) IsZero() bool {
+	return options == ResizeOptions{}
+}
+
+func (options ResizeOptions) isValid() bool {
+	return options.MaxWidth >= 0 && options.MaxWidth <= 16384 &&
+		options.MaxHeight >= 0 && options.MaxHeight <= 16384 &&
+		options.Quality >= 0 && options.Quality <= 100
+}
+
+// Resized JPEG pages stay JPEG, and everything else becomes PNG, so
+// transparency isn't lost. Quality only applies to JPEG.
+func encodeResizedPage(w io.Writer, img image.Image, sourceType string, quality int) error {
+	if sourceType == "image/jpeg" {
+		return jpeg.Encode(w, img, &jpeg.Options{Quality: quality})
+	}
+	return png.Encode(w, img)
+}
+
+// Opens the file to send for a page resized according to options, and returns
+// it along with where it is. Resized pages are kept in the resize cache, named
+// after the page's content and modification time, so a changed page never
+// gets an outdated variant. Pages that don't need resizing or can't be
+// decoded, like WebP ones, are returned as they are.
+func (db *Database) GetResizedPage(username string, token string, pageId int, options ResizeOptions) (io.ReadCloser, PageFile, error) {
+	_, err := db.authenticateUser(username, token)
+	if err != nil {
+		return nil, PageFile{}, err
+	}
+
+	if !options.isValid() {
+		return nil, PageFile{}, DatabaseErrorInvalidResizeOptions
+	}
+
+	var pageFile PageFile
+	var contentHash string
+	var pageWidth, pageHeight int
+	var unavailable bool
+	err = db.db.QueryRow(
+		`SELECT DoujinPages.page_path, DoujinPages.archive_entry, DoujinPages.content_hash,
+			DoujinPages.width, DoujinPages.height, Doujins.unavailable
+		 FROM DoujinPages JOIN Doujins ON Doujins.id = DoujinPages.doujin_id
+		 WHERE DoujinPages.id = ?`,
+		pageId,
+	).Scan(&pageFile.Path, &pageFile.ArchiveEntry, &contentHash, &pageWidth, &pageHeight, &unavailable)
+
+	if err == sql.ErrNoRows {
+		return nil, PageFile{}, DatabaseErrorInvalidId
+	}
+
+	if err != nil {
+		return nil, PageFile{}, err
+	}
+
+	if unavailable {
+		return nil, PageFile{}, DatabaseErrorUnavailableDoujin
+	}
+
+	original := func() (io.ReadCloser, PageFile, error) {
+		file, err := pageFile.Open()
+		if err != nil {
+			return nil, PageFile{}, err
+		}
+		return file, pageFile, nil
+	}
+
+	sourceType := imageTypeFromExtension(pageFile.Name())
+	reencode := sourceType == "image/jpeg" && options.Quality != 0
+
+	// Pages that are known to be small enough don't even need to be decoded
+	if pageWidth > 0 && pageHeight > 0 && !reencode {
+		width, height := fitDimensions(pageWidth, pageHeight, options.MaxWidth, options.MaxHeight)
+		if width == pageWidth && height == pageHeight {
+			return original()
+		}
+	}
+
+	pageInfo, err := os.Stat(pageFile.Path)
+	if err != nil {
+		return nil, PageFile{}, err
+	}
+
+	quality := options.Quality
+	if quality == 0 {
+		quality = DefaultResizeJpegQuality
+	}
+
+	extension := ".png"
+	if sourceType == "image/jpeg" {
+		extension = ".jpg"
+	}
+
+	source := contentHash
+	if source == "" {
+		source = pageFile.String()
+	}
+	key := sha256.Sum256(fmt.Appendf(nil, "%s\n%d\n%d\n%d\n%d",
+		source, pageInfo.ModTime().UnixNano(), options.MaxWidth, options.MaxHeight, quality))
+	cacheName := hex.EncodeToString(key[:]) + extension
+
+	// A cached file that can't be opened is generated again
+	if cachedFile, ok := db.resizeCache.Get(cacheName); ok {
+		return cachedFile, PageFile{Path: cachedFile.Name()}, nil
+	}
+
+	cachedFile, err := func() (*os.File, error) {
+		file, err := pageFile.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		img, _, err := image.Decode(file)
+		if err != nil {
+			return nil, err
+		}
+
+		width, height := fitDimensions(img.Bounds().Dx(), img.Bounds().Dy(), options.MaxWidth, options.MaxHeight)
+		if width == img.Bounds().Dx() && height == img.Bounds().Dy() && !reencode {
+			return nil, nil
+		}
+
+		resized := resizeImage(img, width, height)
+		if sourceType == "image/jpeg" {
+			flattenImage(resized)
+		}
+
+		return db.resizeCache.Put(cacheName, func(w io.Writer) error {
+			return encodeResizedPage(w, resized, sourceType, quality)
+		})
+	}()
+
+	if err != nil {
+		if !errors.Is(err, image.ErrFormat) {
+			log.Printf("Failed to resize page `%s`: %v\n", pageFile, err)
+		}
+		return original()
+	}
+
+	// The page was already small enough
+	if cachedFile == nil {
+		return original()
+	}
+
+	return cachedFile, PageFile{Path: cachedFile.Name()}, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// Weights of the columns of DoujinsSearch when ranking results, in the order
+// they're declared: title, subtitle, artists, groups, characters and tags.
+const searchRankFunction = `bm25(DoujinsSearch, 10.0, 5.0, 3.0, 3.0, 2.0, 1.0)`
+
+// Values a search result must have, or must not have, in each of the list
+// fields of a doujin. Values are compared exactly.
+type SearchFilters struct {
+	Tags           []string
+	AntiTags       []string
+	Artists        []string
+	AntiArtists    []string
+	Groups         []string
+	AntiGroups     []string
+	Characters     []string
+	AntiCharacters []string
+	Languages      []string
+	AntiLanguages  []string
+}
+
+type searchColumnFilter struct {
+	column  string
+	include []string
+	exclude []string
+}
+
+func (filters SearchFilters) columnFilters() []searchColumnFilter {
+	return []searchColumnFilter{
+		{"tags", filters.Tags, filters.AntiTags},
+		{"artists", filters.Artists, filters.AntiArtists},
+		{"groups", filters.Groups, filters.AntiGroups},
+		{"characters", filters.Characters, filters.AntiCharacters},
+		{"languages", filters.Languages, filters.AntiLanguages},
+	}
+}
+
+// Quotes s as an FTS5 string, so its characters are never taken as operators.
+func quoteFtsString(s string) string {
+	return `"` + strings.ReplaceAll(s, `"`, `""`) + `"`
+}
+
+// Runes the unicode61 tokenizer keeps in words
+func isWordRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsNumber(r)
+}
+
+// How search results are sorted. By is one of the keys of searchSortColumns,
+// "relevance" or "random", and Order is "asc" or "desc". Empty values get
+// the defaults chosen by resolve. Seed picks one of the random orders, which
+// stay the same for the same seed so results can be paginated.
+type SearchSort struct {
+	By    string
+	Order string
+	Seed  int64
+}
+
+// Expressions results are sorted by, for each sort that's a plain column.
+// All of them are indexed.
+var searchSortColumns = map[string]string{
+	"upload_date":     "Doujins.upload_date",
+	"import_date":     "Doujins.id",
+	"title":           "Doujins.title COLLATE NOCASE",
+	"external_rating": "Doujins.external_rating",
+	"pages":           "Doujins.pages",
+}
+
+func (sort SearchSort) isValid() bool {
+	_, isColumn := searchSortColumns[sort.By]
+	validBy := isColumn || sort.By == "" || sort.By == "relevance" || sort.By == "random"
+	validOrder := sort.Order == "" || sort.Order == "asc" || sort.Order == "desc"
+	return validBy && validOrder
+}
+
+// Fills in the defaults: results are sorted by relevance when the query has
+// text to rank them by, and by upload date otherwise. Sorting by relevance
+// without text falls back to the upload date too. Titles and random orders
+// are ascending by default, and everything else descending, so the most
+// relevant, newest, best rated and longest doujins come first.
+func (sort SearchSort) resolve(ranked bool) SearchSort {
+	if sort.By == "" || (sort.By == "relevance" && !ranked) {
+		sort.By = "upload_date"
+		if ranked {
+			sort.By = "relevance"
+		}
+	}
+
+	if sort.Order == "" {
+		sort.Order = "desc"
+		if sort.By == "title" || sort.By == "random" {
+			sort.Order = "asc"
+		}
+	}
+
+	return sort
+}
+
+// Returns the ORDER BY clause of a resolved sort. Ties are broken by ID, so
+// results keep the same order between pages.
+func (sort SearchSort) orderBy() string {
+	direction := " ASC"
+	if sort.Order == "desc" {
+		direction = " DESC"
+	}
+
+	switch sort.By {
+	case "relevance":
+		// Lower bm25 scores are better matches. Doujins only matched by
+		// other terms, like in "a OR tag:b", have no rank and go last.
+		reversed := " DESC"
+		if sort.Order == "desc" {
+			reversed = " ASC"
+		}
+		return "ORDER BY COALESCE(SearchRank.rank, 0)" + reversed + ", Doujins.upload_date DESC, Doujins.id DESC"
+	case "random":
+		return "ORDER BY " + seededRandomKey(sort.Seed) + direction + ", Doujins.id" + direction
+	default:
+		return "ORDER BY " + searchSortColumns[sort.By] + direction + ", Doujins.id" + direction
+	}
+}
+
+// Returns an SQL expression that hashes the ID of a doujin with seed, so
+// doujins are shuffled the same way every time for the same seed. SQLite has
+// no XOR, so a ^ b is written as (a | b) - (a & b), and every step is a
+// subquery so its value isn't repeated in the expression. Values are kept
+// below 2^32, so multiplying them never overflows.
+func seededRandomKey(seed int64) string {
+	xor := func(a string, b string) string {
+		return "((" + a + " | " + b + ") - (" + a + " & " + b + "))"
+	}
+	step := func(h string, expression string) string {
+		return "(SELECT " + expression + " FROM (SELECT " + h + " AS h))"
+	}
+
+	seed = ((seed % 4294967296) + 4294967296) % 4294967296
+	h := xor("(Doujins.id % 4294967296)", fmt.Sprint(seed))
+	h = step(h, xor("(h >> 16)", "h")+" * 73244475 % 4294967296")
+	h = step(h, xor("(h >> 16)", "h")+" * 73244475 % 4294967296")
+	return step(h, xor("(h >> 16)", "h"))
+}
@@ -0,0 +1,545 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// A syntax error in a search query. Position is the index, in characters, of
+// where the error is in the query.
+type SearchQueryError struct {
+	Position int
+	Message  string
+}
+
+func (err *SearchQueryError) Error() string {
+	return fmt.Sprintf("%s at position %d", err.Message, err.Position)
+}
+
+// A parsed search query, or part of one. writeSql writes it to query as a
+// condition on the Doujins table, and returns parameters with the parameters
+// of the condition appended.
+type searchNode interface {
+	writeSql(query *strings.Builder, parameters []any) []any
+}
+
+// Matches doujins that match all of its nodes
+type searchAnd []searchNode
+
+// Matches doujins that match any of its nodes
+type searchOr []searchNode
+
+type searchNot struct {
+	node searchNode
+}
+
+// Words and phrases searched for in DoujinsSearch. Words match any word that
+// starts with them. An empty column means any column.
+type searchText struct {
+	column string
+	text   string
+	phrase bool
+}
+
+// Text without letters or digits, like "-", has no words to search for, so
+// it's left out of the query by returning nil.
+func newSearchText(column string, text string, phrase bool) searchNode {
+	if strings.IndexFunc(text, isWordRune) == -1 {
+		return nil
+	}
+	return searchText{column, text, phrase}
+}
+
+// Matches doujins with value in one of the list columns of Doujins, like tags
+type searchListValue struct {
+	column string
+	value  string
+}
+
+// Matches doujins whose expression is at least lower and less than upper.
+// Infinite bounds are left out.
+type searchRange struct {
+	expression string
+	lower      float64
+	upper      float64
+}
+
+func writeSearchNodes(query *strings.Builder, parameters []any, nodes []searchNode, operator string) []any {
+	query.WriteString("(")
+	for i, node := range nodes {
+		if i > 0 {
+			query.WriteString(" " + operator + " ")
+		}
+		parameters = node.writeSql(query, parameters)
+	}
+	query.WriteString(")")
+	return parameters
+}
+
+func (nodes searchAnd) writeSql(query *strings.Builder, parameters []any) []any {
+	return writeSearchNodes(query, parameters, nodes, "AND")
+}
+
+func (nodes searchOr) writeSql(query *strings.Builder, parameters []any) []any {
+	return writeSearchNodes(query, parameters, nodes, "OR")
+}
+
+func (not searchNot) writeSql(query *strings.Builder, parameters []any) []any {
+	query.WriteString("NOT ")
+	return not.node.writeSql(query, parameters)
+}
+
+func (text searchText) ftsQuery() string {
+	ftsQuery := quoteFtsString(text.text)
+	if !text.phrase {
+		ftsQuery += "*"
+	}
+	if text.column != "" {
+		ftsQuery = text.column + " : " + ftsQuery
+	}
+	return ftsQuery
+}
+
+func (text searchText) writeSql(query *strings.Builder, parameters []any) []any {
+	query.WriteString("Doujins.id IN (SELECT rowid FROM DoujinsSearch WHERE DoujinsSearch MATCH ?)")
+	return append(parameters, text.ftsQuery())
+}
+
+func (list searchListValue) writeSql(query *strings.Builder, parameters []any) []any {
+	query.WriteString(`EXISTS (
+		SELECT 1 FROM json_each(Doujins.` + list.column + `) AS jt
+		WHERE jt.value = ? COLLATE NOCASE
+	)`)
+	return append(parameters, list.value)
+}
+
+func (r searchRange) writeSql(query *strings.Builder, parameters []any) []any {
+	query.WriteString("(1")
+	if !math.IsInf(r.lower, -1) {
+		query.WriteString(" AND " + r.expression + " >= ?")
+		parameters = append(parameters, r.lower)
+	}
+	if !math.IsInf(r.upper, 1) {
+		query.WriteString(" AND " + r.expression + " < ?")
+		parameters = append(parameters, r.upper)
+	}
+	query.WriteString(")")
+	return parameters
+}
+
+// Returns an FTS5 query matching any of the text node matches, outside of
+// negations, to rank results with. Empty if node has no text to rank by.
+func searchRankQuery(node searchNode) string {
+	terms := []string{}
+
+	var collect func(node searchNode)
+	collect = func(node searchNode) {
+		switch node := node.(type) {
+		case searchAnd:
+			for _, child := range node {
+				collect(child)
+			}
+		case searchOr:
+			for _, child := range node {
+				collect(child)
+			}
+		case searchText:
+			terms = append(terms, node.ftsQuery())
+		}
+	}
+
+	if node != nil {
+		collect(node)
+	}
+	return strings.Join(terms, " OR ")
+}
+
+type searchFieldKind int
+
+const (
+	searchFieldText searchFieldKind = iota
+	searchFieldList
+	searchFieldNumber
+	searchFieldDate
+)
+
+type searchField struct {
+	kind searchFieldKind
+
+	// Column of DoujinsSearch for text fields, column of Doujins for list
+	// fields and SQL expression for number and date fields
+	column string
+}
+
+var searchFields = map[string]searchField{
+	"title":      {searchFieldText, "title"},
+	"subtitle":   {searchFieldText, "subtitle"},
+	"tag":        {searchFieldList, "tags"},
+	"tags":       {searchFieldList, "tags"},
+	"artist":     {searchFieldList, "artists"},
+	"artists":    {searchFieldList, "artists"},
+	"group":      {searchFieldList, "groups"},
+	"groups":     {searchFieldList, "groups"},
+	"character":  {searchFieldList, "characters"},
+	"characters": {searchFieldList, "characters"},
+	"lang":       {searchFieldList, "languages"},
+	"language":   {searchFieldList, "languages"},
+	"languages":  {searchFieldList, "languages"},
+	"pages":      {searchFieldNumber, "Doujins.pages"},
+	"rating":     {searchFieldNumber, "Doujins.external_rating"},
+	"date":       {searchFieldDate, "julianday(Doujins.upload_date)"},
+}
+
+// Parses a number as the range of numbers it stands for, [n, n+1).
+func parseSearchNumber(value string) (float64, float64, bool) {
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return float64(n), float64(n + 1), true
+}
+
+func julianDay(t time.Time) float64 {
+	return float64(t.Unix())/86400 + 2440587.5
+}
+
+// Parses a year, month or day in UTC as the range of Julian days it lasts.
+func parseSearchDate(value string) (float64, float64, bool) {
+	layouts := []struct {
+		layout string
+		years  int
+		months int
+		days   int
+	}{
+		{"2006", 1, 0, 0},
+		{"2006-01", 0, 1, 0},
+		{"2006-01-02", 0, 0, 1},
+	}
+
+	for _, l := range layouts {
+		start, err := time.Parse(l.layout, value)
+		if err == nil {
+			return julianDay(start), julianDay(start.AddDate(l.years, l.months, l.days)), true
+		}
+	}
+	return 0, 0, false
+}
+
+type searchQueryParser struct {
+	query    []rune
+	position int
+}
+
+func (p *searchQueryParser) errorf(position int, format string, a ...any) error {
+	return &SearchQueryError{position, fmt.Sprintf(format, a...)}
+}
+
+func (p *searchQueryParser) atEnd() bool {
+	return p.position >= len(p.query)
+}
+
+func (p *searchQueryParser) peek() rune {
+	if p.atEnd() {
+		return 0
+	}
+	return p.query[p.position]
+}
+
+func (p *searchQueryParser) skipSpaces() {
+	for !p.atEnd() && unicode.IsSpace(p.peek()) {
+		p.position++
+	}
+}
+
+// Whether the next word is the OR operator
+func (p *searchQueryParser) atOr() bool {
+	end := p.position + 2
+	if end > len(p.query) || string(p.query[p.position:end]) != "OR" {
+		return false
+	}
+	return end == len(p.query) || unicode.IsSpace(p.query[end]) || p.query[end] == '('
+}
+
+// Terms, separated by spaces, until the end of the query or of the group
+func (p *searchQueryParser) parseAnd() (searchAnd, error) {
+	nodes := searchAnd{}
+	for {
+		p.skipSpaces()
+		if p.atEnd() || p.peek() == ')' {
+			return nodes, nil
+		}
+
+		if p.atOr() {
+			return nil, p.errorf(p.position, "Expected a search term before `OR`")
+		}
+
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+}
+
+// OR binds tighter than the spaces between terms, so "a b OR c" is
+// "a (b OR c)". Terms with nothing to search for are left out, and so is the
+// whole OR if all of them are.
+func (p *searchQueryParser) parseOr() (searchNode, error) {
+	node, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	nodes := searchOr{}
+	if node != nil {
+		nodes = append(nodes, node)
+	}
+	for {
+		position := p.position
+		p.skipSpaces()
+		if !p.atOr() {
+			p.position = position
+			break
+		}
+
+		orPosition := p.position
+		p.position += 2
+		p.skipSpaces()
+		if p.atEnd() || p.peek() == ')' || p.atOr() {
+			return nil, p.errorf(orPosition, "Expected a search term after `OR`")
+		}
+
+		node, err = p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if node != nil {
+			nodes = append(nodes, node)
+		}
+	}
+
+	switch len(nodes) {
+	case 0:
+		return nil, nil
+	case 1:
+		return nodes[0], nil
+	}
+	return nodes, nil
+}
+
+func (p *searchQueryParser) parseUnary() (searchNode, error) {
+	if p.peek() != '-' {
+		return p.parsePrimary()
+	}
+
+	start := p.position
+	p.position++
+	if p.atEnd() || unicode.IsSpace(p.peek()) || p.peek() == ')' {
+		return nil, p.errorf(start, "Expected a search term after `-`")
+	}
+
+	node, err := p.parseUnary()
+	if err != nil || node == nil {
+		return nil, err
+	}
+	return searchNot{node}, nil
+}
+
+func (p *searchQueryParser) parsePrimary() (searchNode, error) {
+	start := p.position
+
+	switch p.peek() {
+	case '(':
+		p.position++
+		p.skipSpaces()
+		if p.peek() == ')' {
+			return nil, p.errorf(start, "Empty parentheses")
+		}
+
+		nodes, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+
+		if p.atEnd() {
+			return nil, p.errorf(start, "Unclosed `(`")
+		}
+		p.position++
+
+		switch len(nodes) {
+		case 0:
+			return nil, nil
+		case 1:
+			return nodes[0], nil
+		}
+		return nodes, nil
+	case '"':
+		text, err := p.parsePhrase()
+		if err != nil {
+			return nil, err
+		}
+		return newSearchText("", text, true), nil
+	}
+
+	word := p.parseWord(true)
+	if p.peek() != ':' {
+		return newSearchText("", word, false), nil
+	}
+
+	// Words with a colon that isn't after a field, like "Re:Zero" or URLs,
+	// are searched for as they are
+	field, ok := searchFields[strings.ToLower(word)]
+	if !ok {
+		p.position = start
+		return newSearchText("", p.parseWord(false), false), nil
+	}
+	p.position++
+
+	valueStart := p.position
+	var value string
+	phrase := p.peek() == '"'
+	if phrase {
+		var err error
+		value, err = p.parsePhrase()
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		value = p.parseWord(false)
+	}
+
+	if value == "" {
+		return nil, p.errorf(valueStart, "Expected a value for `%s:`", word)
+	}
+
+	switch field.kind {
+	case searchFieldText:
+		return newSearchText(field.column, value, phrase), nil
+	case searchFieldList:
+		return searchListValue{field.column, value}, nil
+	case searchFieldNumber:
+		return p.parseRange(field.column, value, valueStart, parseSearchNumber, "Invalid number `%s`")
+	default:
+		return p.parseRange(field.column, value, valueStart, parseSearchDate,
+			"Invalid date `%s`; dates are written as YYYY, YYYY-MM or YYYY-MM-DD")
+	}
+}
+
+// Reads until a space, a parenthesis, a double quote or, if stopAtColon is
+// true, a colon.
+func (p *searchQueryParser) parseWord(stopAtColon bool) string {
+	start := p.position
+	for !p.atEnd() {
+		r := p.peek()
+		if unicode.IsSpace(r) || r == '(' || r == ')' || r == '"' || (stopAtColon && r == ':') {
+			break
+		}
+		p.position++
+	}
+	return string(p.query[start:p.position])
+}
+
+func (p *searchQueryParser) parsePhrase() (string, error) {
+	start := p.position
+	p.position++
+	for !p.atEnd() && p.peek() != '"' {
+		p.position++
+	}
+
+	if p.atEnd() {
+		return "", p.errorf(start, "Unclosed `\"`")
+	}
+	p.position++
+
+	return string(p.query[start+1 : p.position-1]), nil
+}
+
+// Parses comparisons like ">20", "<=2020-06" and "10..20". Values stand for
+// ranges, like days for dates, so "<=2020" includes all of 2020 and ">2020"
+// starts in 2021.
+func (p *searchQueryParser) parseRange(
+	expression string,
+	value string,
+	position int,
+	parse func(value string) (float64, float64, bool),
+	invalidFormat string,
+) (searchNode, error) {
+	r := searchRange{expression, math.Inf(-1), math.Inf(1)}
+
+	parseAt := func(value string, offset int) (float64, float64, error) {
+		start, end, ok := parse(value)
+		if !ok {
+			return 0, 0, p.errorf(position+offset, invalidFormat, value)
+		}
+		return start, end, nil
+	}
+
+	var err error
+	var start, end float64
+	switch {
+	case strings.HasPrefix(value, ">="):
+		r.lower, _, err = parseAt(value[2:], 2)
+	case strings.HasPrefix(value, "<="):
+		_, r.upper, err = parseAt(value[2:], 2)
+	case strings.HasPrefix(value, ">"):
+		_, r.lower, err = parseAt(value[1:], 1)
+	case strings.HasPrefix(value, "<"):
+		r.upper, _, err = parseAt(value[1:], 1)
+	case strings.HasPrefix(value, "="):
+		r.lower, r.upper, err = parseAt(value[1:], 1)
+	case strings.Contains(value, ".."):
+		lower, upper, _ := strings.Cut(value, "..")
+		if lower == "" && upper == "" {
+			return nil, p.errorf(position, invalidFormat, value)
+		}
+
+		if lower != "" {
+			r.lower, _, err = parseAt(lower, 0)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if upper != "" {
+			_, r.upper, err = parseAt(upper, len([]rune(lower))+2)
+		}
+	default:
+		start, end, err = parseAt(value, 0)
+		r.lower, r.upper = start, end
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Parses a search query. Words and phrases between double quotes are
+// searched for in the full-text index, "field:value" terms match the fields
+// in searchFields, "-" negates the term after it, "OR" matches either of the
+// terms around it and parentheses group terms. Returns nil for queries with
+// nothing to search for.
+func ParseSearchQuery(query string) (searchNode, error) {
+	p := searchQueryParser{query: []rune(query)}
+
+	nodes, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	// parseAnd only stops early at an unmatched ")"
+	if !p.atEnd() {
+		return nil, p.errorf(p.position, "Unexpected `)`")
+	}
+
+	if len(nodes) == 0 {
+		return nil, nil
+	}
+	return nodes, nil
+}
@@ -0,0 +1,345 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+type SeriesChapter struct {
+	Number      int    `json:"number"`
+	DoujinId    int    `json:"doujin_id"`
+	Title       string `json:"title"`
+	Subtitle    string `json:"subtitle"`
+	Pages       int    `json:"pages"`
+	CoverPageId int    `json:"cover_page_id"`
+}
+
+type Series struct {
+	Id       int             `json:"id"`
+	Title    string          `json:"title"`
+	Chapters []SeriesChapter `json:"chapters"`
+}
+
+const seriesChapterColumns = `chapter_number, id, title, subtitle, pages,
+	COALESCE((SELECT id FROM DoujinPages WHERE doujin_id = Doujins.id AND page_number = 1), 0)`
+
+func scanSeriesChapter(row interface{ Scan(...any) error }) (SeriesChapter, error) {
+	var chapter SeriesChapter
+	err := row.Scan(&chapter.Number, &chapter.DoujinId, &chapter.Title, &chapter.Subtitle, &chapter.Pages, &chapter.CoverPageId)
+	return chapter, err
+}
+
+// Returns the series with ID seriesId and its chapters in order. Unavailable
+// chapters are left out.
+func (db *Database) GetSeries(username string, token string, seriesId int) (Series, error) {
+	_, err := db.authenticateUser(username, token)
+	if err != nil {
+		return Series{}, err
+	}
+
+	series := Series{Id: seriesId, Chapters: []SeriesChapter{}}
+	err = db.db.QueryRow(`SELECT title FROM Series WHERE id = ?`, seriesId).Scan(&series.Title)
+	if err == sql.ErrNoRows {
+		return Series{}, DatabaseErrorInvalidId
+	}
+
+	if err != nil {
+		return Series{}, err
+	}
+
+	rows, err := db.db.Query(
+		`SELECT `+seriesChapterColumns+`
+		 FROM Doujins
+		 WHERE series_id = ? AND unavailable = 0
+		 ORDER BY chapter_number`,
+		seriesId,
+	)
+	if err != nil {
+		return Series{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		chapter, err := scanSeriesChapter(rows)
+		if err != nil {
+			return Series{}, err
+		}
+		series.Chapters = append(series.Chapters, chapter)
+	}
+
+	return series, rows.Err()
+}
+
+// Returns the chapter that comes after the doujin with ID doujinId in its
+// series, or the one that comes before it if next is false. Unavailable
+// chapters are skipped.
+func (db *Database) GetAdjacentChapter(username string, token string, doujinId int, next bool) (SeriesChapter, error) {
+	_, err := db.authenticateUser(username, token)
+	if err != nil {
+		return SeriesChapter{}, err
+	}
+
+	var seriesId sql.NullInt64
+	var chapterNumber int
+	err = db.db.QueryRow(
+		`SELECT series_id, chapter_number FROM Doujins WHERE id = ?`,
+		doujinId,
+	).Scan(&seriesId, &chapterNumber)
+
+	if err == sql.ErrNoRows {
+		return SeriesChapter{}, DatabaseErrorInvalidId
+	}
+
+	if err != nil {
+		return SeriesChapter{}, err
+	}
+
+	if !seriesId.Valid {
+		return SeriesChapter{}, DatabaseErrorNotInSeries
+	}
+
+	query := `SELECT ` + seriesChapterColumns + `
+		FROM Doujins
+		WHERE series_id = ? AND unavailable = 0 AND chapter_number > ?
+		ORDER BY chapter_number
+		LIMIT 1`
+	if !next {
+		query = `SELECT ` + seriesChapterColumns + `
+			FROM Doujins
+			WHERE series_id = ? AND unavailable = 0 AND chapter_number < ?
+			ORDER BY chapter_number DESC
+			LIMIT 1`
+	}
+
+	chapter, err := scanSeriesChapter(db.db.QueryRow(query, seriesId.Int64, chapterNumber))
+	if err == sql.ErrNoRows {
+		return SeriesChapter{}, DatabaseErrorNoAdjacentChapter
+	}
+
+	if err != nil {
+		return SeriesChapter{}, err
+	}
+
+	return chapter, nil
+}
+
+type ImportSeriesResult struct {
+	SeriesId int
+	Imported int
+	Failures []BulkImportFailure
+}
+
+// Imports every subfolder and CBZ/ZIP archive in folderPath as a chapter of
+// a new series called title, or named after the folder if title is empty.
+// Chapters are numbered in natural order of their names (see naturalCompare).
+// Nothing is imported unless every chapter is valid; chapters that still
+// fail to import, like refused duplicates, are listed in the result and leave
+// a gap in the numbering.
+func (db *Database) ImportSeries(folderPath string, title string, options ImportOptions) (ImportSeriesResult, error) {
+	absolutePath, err := filepath.Abs(folderPath)
+	if err != nil {
+		return ImportSeriesResult{}, err
+	}
+
+	if title == "" {
+		title = filepath.Base(absolutePath)
+	}
+
+	entries, err := os.ReadDir(absolutePath)
+	if err != nil {
+		return ImportSeriesResult{}, err
+	}
+
+	chapterNames := []string{}
+	for _, e := range entries {
+		if !e.IsDir() && !isArchivePath(e.Name()) {
+			continue
+		}
+		chapterNames = append(chapterNames, e.Name())
+	}
+	slices.SortFunc(chapterNames, naturalCompare)
+
+	if len(chapterNames) == 0 {
+		return ImportSeriesResult{}, fmt.Errorf("`%s` has no chapter folders or archives", folderPath)
+	}
+
+	problems := []error{}
+	for _, name := range chapterNames {
+		err = ValidateDoujin(filepath.Join(absolutePath, name), options)
+		if err == nil {
+			continue
+		}
+
+		for _, problem := range appendProblems(nil, err) {
+			problems = append(problems, fmt.Errorf("Chapter `%s`: %w", name, problem))
+		}
+	}
+
+	if len(problems) > 0 {
+		return ImportSeriesResult{}, &ValidationError{problems}
+	}
+
+	result, err := db.db.Exec(`INSERT INTO Series (title) VALUES (?)`, title)
+	if err != nil {
+		return ImportSeriesResult{}, err
+	}
+
+	seriesId, err := result.LastInsertId()
+	if err != nil {
+		return ImportSeriesResult{}, err
+	}
+
+	importResult := ImportSeriesResult{
+		SeriesId: int(seriesId),
+		Failures: []BulkImportFailure{},
+	}
+
+	for i, name := range chapterNames {
+		chapterPath := filepath.Join(absolutePath, name)
+
+		chapterOptions := options
+		chapterOptions.SeriesId = int(seriesId)
+		chapterOptions.ChapterNumber = i + 1
+
+		_, err := db.ImportDoujin(chapterPath, chapterOptions)
+		if err != nil {
+			importResult.Failures = append(importResult.Failures, BulkImportFailure{chapterPath, err.Error()})
+			continue
+		}
+
+		importResult.Imported++
+	}
+
+	if importResult.Imported == 0 {
+		_, err = db.db.Exec(`DELETE FROM Series WHERE id = ?`, seriesId)
+		if err != nil {
+			return importResult, err
+		}
+		importResult.SeriesId = 0
+	}
+
+	return importResult, nil
+}
+
+// Makes the doujin with ID doujinId chapter chapterNumber of the series with
+// ID seriesId, moving it out of the series it was in.
+func setChapter(tx *sql.Tx, doujinId int, seriesId int, chapterNumber int) error {
+	err := tx.QueryRow(`SELECT 1 FROM Doujins WHERE id = ?`, doujinId).Scan(new(int))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("Doujin %d: %w", doujinId, DatabaseErrorInvalidId)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if chapterNumber < 1 {
+		return fmt.Errorf("Chapter numbers must be greater than 0 (got %d)", chapterNumber)
+	}
+
+	var otherId int
+	err = tx.QueryRow(
+		`SELECT id FROM Doujins WHERE series_id = ? AND chapter_number = ? AND id != ?`,
+		seriesId, chapterNumber, doujinId,
+	).Scan(&otherId)
+	if err == nil {
+		return fmt.Errorf("Chapter %d of series %d is already doujin %d", chapterNumber, seriesId, otherId)
+	}
+
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	_, err = tx.Exec(`UPDATE Doujins SET series_id = ?, chapter_number = ? WHERE id = ?`, seriesId, chapterNumber, doujinId)
+	return err
+}
+
+// Series whose last chapter was deleted or moved go too
+func deleteEmptySeries(tx *sql.Tx) error {
+	_, err := tx.Exec(`DELETE FROM Series WHERE id NOT IN (SELECT series_id FROM Doujins WHERE series_id IS NOT NULL)`)
+	return err
+}
+
+// Creates a series called title whose chapters are the doujins with IDs
+// doujinIds, in order, and returns its ID. Doujins that were in another series
+// are moved out of it.
+func (db *Database) CreateSeries(title string, doujinIds []int) (int, error) {
+	if strings.TrimSpace(title) == "" {
+		return 0, fmt.Errorf("The title is empty")
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`INSERT INTO Series (title) VALUES (?)`, title)
+	if err != nil {
+		return 0, err
+	}
+
+	seriesId, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	for i, doujinId := range doujinIds {
+		if slices.Contains(doujinIds[:i], doujinId) {
+			return 0, fmt.Errorf("Doujin %d is listed more than once", doujinId)
+		}
+
+		err = setChapter(tx, doujinId, int(seriesId), i+1)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	err = deleteEmptySeries(tx)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(seriesId), nil
+}
+
+// Makes the doujin with ID doujinId chapter chapterNumber of the existing
+// series with ID seriesId, moving it out of the series it was in. Chapter
+// numbers can't be shared, but can have gaps.
+func (db *Database) SetChapter(doujinId int, seriesId int, chapterNumber int) error {
+	tx, err := db.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`SELECT 1 FROM Series WHERE id = ?`, seriesId).Scan(new(int))
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("Series %d: %w", seriesId, DatabaseErrorInvalidId)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	err = setChapter(tx, doujinId, seriesId, chapterNumber)
+	if err != nil {
+		return err
+	}
+
+	err = deleteEmptySeries(tx)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
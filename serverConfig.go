@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"path/filepath"
 )
 
 type ServerConfig struct {
@@ -11,7 +12,26 @@ type ServerConfig struct {
 	DatabasePath string `json:"database_path"`
 	Port         int    `json:"port"`
 
-	DisableRegistering bool `json:"disable_registering"`
+	DisableRegistering bool     `json:"disable_registering"`
+	AdminUsers         []string `json:"admin_users"`
+
+	WatchDirectories   []string `json:"watch_directories"`
+	WatchSettleSeconds int      `json:"watch_settle_seconds"`
+
+	DuplicatePolicy       string `json:"duplicate_policy"`
+	SimilarityMaxDistance int    `json:"similarity_max_distance"`
+
+	LibraryPath string `json:"library_path"`
+	LibraryMode string `json:"library_mode"`
+
+	ThumbnailCachePath string `json:"thumbnail_cache_path"`
+	ThumbnailSizes     []int  `json:"thumbnail_sizes"`
+
+	ResizeCachePath      string `json:"resize_cache_path"`
+	ResizeCacheMaxSizeMB int    `json:"resize_cache_max_size_mb"`
+
+	UploadPath      string `json:"upload_path"`
+	UploadMaxSizeMB int    `json:"upload_max_size_mb"`
 }
 
 func LoadServerConfig() ServerConfig {
@@ -68,6 +88,142 @@ func LoadServerConfig() ServerConfig {
 		os.Exit(1)
 	}
 
+	adminUsers := serverConfig.AdminUsers
+	if adminUsers == nil {
+		adminUsers = []string{}
+	}
+
+	watchDirectories := []string{}
+	for _, directory := range serverConfig.WatchDirectories {
+		absoluteDirectory, err := filepath.Abs(directory)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: invalid watch directory `%s` specified in configuration file: %v\n", directory, err)
+			os.Exit(1)
+		}
+
+		info, err := os.Stat(absoluteDirectory)
+		if err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "ERROR: could not find watch directory `%s` specified in configuration file\n", directory)
+			os.Exit(1)
+		}
+
+		watchDirectories = append(watchDirectories, absoluteDirectory)
+	}
+
+	if serverConfig.WatchSettleSeconds < 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid watch settle time specified in configuration file\n")
+		os.Exit(1)
+	}
+
+	watchSettleSeconds := serverConfig.WatchSettleSeconds
+	if watchSettleSeconds == 0 {
+		watchSettleSeconds = 60
+	}
+
+	duplicatePolicy := serverConfig.DuplicatePolicy
+	switch duplicatePolicy {
+	case "":
+		duplicatePolicy = DuplicatePolicyWarn
+	case DuplicatePolicySkip, DuplicatePolicyWarn, DuplicatePolicyImport:
+	default:
+		fmt.Fprintf(os.Stderr, "ERROR: invalid duplicate policy specified in configuration file\n")
+		os.Exit(1)
+	}
+
+	if serverConfig.SimilarityMaxDistance < 0 || serverConfig.SimilarityMaxDistance > 64 {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid similarity max distance specified in configuration file\n")
+		os.Exit(1)
+	}
+
+	similarityMaxDistance := serverConfig.SimilarityMaxDistance
+	if similarityMaxDistance == 0 {
+		similarityMaxDistance = 10
+	}
+
+	libraryPath := ""
+	if serverConfig.LibraryPath != "" {
+		libraryPath, err = filepath.Abs(serverConfig.LibraryPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: invalid library path specified in configuration file: %v\n", err)
+			os.Exit(1)
+		}
+
+		info, err := os.Stat(libraryPath)
+		if err != nil || !info.IsDir() {
+			fmt.Fprintf(os.Stderr, "ERROR: could not find library directory `%s` specified in configuration file\n", serverConfig.LibraryPath)
+			os.Exit(1)
+		}
+	}
+
+	libraryMode := serverConfig.LibraryMode
+	switch libraryMode {
+	case "":
+		libraryMode = LibraryModeCopy
+	case LibraryModeCopy, LibraryModeHardlink:
+	default:
+		fmt.Fprintf(os.Stderr, "ERROR: invalid library mode specified in configuration file\n")
+		os.Exit(1)
+	}
+
+	thumbnailCachePath := filepath.Join(filepath.Dir(databasePath), "thumbnails")
+	if serverConfig.ThumbnailCachePath != "" {
+		thumbnailCachePath, err = filepath.Abs(serverConfig.ThumbnailCachePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: invalid thumbnail cache path specified in configuration file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	thumbnailSizes := serverConfig.ThumbnailSizes
+	if len(thumbnailSizes) == 0 {
+		thumbnailSizes = []int{320, 640}
+	}
+
+	for _, size := range thumbnailSizes {
+		if size < 16 || size > 4096 {
+			fmt.Fprintf(os.Stderr, "ERROR: invalid thumbnail size %d specified in configuration file\n", size)
+			os.Exit(1)
+		}
+	}
+
+	resizeCachePath := filepath.Join(filepath.Dir(databasePath), "resized")
+	if serverConfig.ResizeCachePath != "" {
+		resizeCachePath, err = filepath.Abs(serverConfig.ResizeCachePath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: invalid resize cache path specified in configuration file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if serverConfig.ResizeCacheMaxSizeMB < 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid resize cache max size specified in configuration file\n")
+		os.Exit(1)
+	}
+
+	resizeCacheMaxSizeMB := serverConfig.ResizeCacheMaxSizeMB
+	if resizeCacheMaxSizeMB == 0 {
+		resizeCacheMaxSizeMB = 1024
+	}
+
+	uploadPath := filepath.Join(filepath.Dir(databasePath), "uploads")
+	if serverConfig.UploadPath != "" {
+		uploadPath, err = filepath.Abs(serverConfig.UploadPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "ERROR: invalid upload path specified in configuration file: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if serverConfig.UploadMaxSizeMB < 0 {
+		fmt.Fprintf(os.Stderr, "ERROR: invalid upload max size specified in configuration file\n")
+		os.Exit(1)
+	}
+
+	uploadMaxSizeMB := serverConfig.UploadMaxSizeMB
+	if uploadMaxSizeMB == 0 {
+		uploadMaxSizeMB = 1024
+	}
+
 	// Return validated struct
 	return ServerConfig{
 		FrontendURL:  serverConfig.FrontendURL,
@@ -75,5 +231,24 @@ func LoadServerConfig() ServerConfig {
 		Port:         serverConfig.Port,
 
 		DisableRegistering: serverConfig.DisableRegistering,
+		AdminUsers:         adminUsers,
+
+		WatchDirectories:   watchDirectories,
+		WatchSettleSeconds: watchSettleSeconds,
+
+		DuplicatePolicy:       duplicatePolicy,
+		SimilarityMaxDistance: similarityMaxDistance,
+
+		LibraryPath: libraryPath,
+		LibraryMode: libraryMode,
+
+		ThumbnailCachePath: thumbnailCachePath,
+		ThumbnailSizes:     thumbnailSizes,
+
+		ResizeCachePath:      resizeCachePath,
+		ResizeCacheMaxSizeMB: resizeCacheMaxSizeMB,
+
+		UploadPath:      uploadPath,
+		UploadMaxSizeMB: uploadMaxSizeMB,
 	}
 }
@@ -0,0 +1,229 @@
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"image"
+	"image/jpeg"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+const ThumbnailJpegQuality = 85
+
+// Thumbnails are named after the content hash of their page, so identical
+// pages share their thumbnails. Pages without a content hash are named after
+// their location instead.
+func thumbnailPath(cachePath string, pageFile PageFile, contentHash string, size int) string {
+	key := contentHash
+	if key == "" {
+		hash := sha256.Sum256([]byte(pageFile.String()))
+		key = hex.EncodeToString(hash[:])
+	}
+
+	return filepath.Join(cachePath, strconv.Itoa(size), key[:2], key+".jpg")
+}
+
+// Returns the path of the thumbnail of a page that fits in size x size
+// pixels, generating it if it isn't cached or if the page's file changed
+// after it was generated. generated tells whether it was generated. Fails if
+// the page can't be decoded.
+func (db *Database) pageThumbnail(pageFile PageFile, contentHash string, size int) (cachedPath string, generated bool, err error) {
+	cachedPath = thumbnailPath(db.serverConfig.ThumbnailCachePath, pageFile, contentHash, size)
+
+	pageInfo, err := os.Stat(pageFile.Path)
+	if err != nil {
+		return "", false, err
+	}
+
+	if cachedInfo, err := os.Stat(cachedPath); err == nil && !cachedInfo.ModTime().Before(pageInfo.ModTime()) {
+		return cachedPath, false, nil
+	}
+
+	file, err := pageFile.Open()
+	if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return "", false, err
+	}
+
+	width, height := fitDimensions(img.Bounds().Dx(), img.Bounds().Dy(), size, size)
+	thumbnail := resizeImage(img, width, height)
+	flattenImage(thumbnail)
+
+	err = writeJpegAtomically(cachedPath, thumbnail, ThumbnailJpegQuality)
+	if err != nil {
+		return "", false, err
+	}
+
+	return cachedPath, true, nil
+}
+
+// Writes to a temporary file first so concurrent readers never see an
+// incomplete image.
+func writeJpegAtomically(filePath string, img image.Image, quality int) error {
+	err := os.MkdirAll(filepath.Dir(filePath), 0755)
+	if err != nil {
+		return err
+	}
+
+	temporaryFile, err := os.CreateTemp(filepath.Dir(filePath), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temporaryFile.Name())
+
+	err = jpeg.Encode(temporaryFile, img, &jpeg.Options{Quality: quality})
+	if err == nil {
+		err = temporaryFile.Chmod(0644)
+	}
+	if err != nil {
+		temporaryFile.Close()
+		return err
+	}
+
+	err = temporaryFile.Close()
+	if err != nil {
+		return err
+	}
+
+	return os.Rename(temporaryFile.Name(), filePath)
+}
+
+// Returns the file to send as the thumbnail of a page, which fits in
+// size x size pixels. If size is 0, the first configured size is used. Pages
+// that can't be decoded, like WebP ones, are returned as they are.
+func (db *Database) GetThumbnail(username string, token string, pageId int, size int) (PageFile, error) {
+	_, err := db.authenticateUser(username, token)
+	if err != nil {
+		return PageFile{}, err
+	}
+
+	if size == 0 {
+		size = db.serverConfig.ThumbnailSizes[0]
+	}
+
+	if !slices.Contains(db.serverConfig.ThumbnailSizes, size) {
+		return PageFile{}, DatabaseErrorInvalidThumbnailSize
+	}
+
+	var pageFile PageFile
+	var contentHash string
+	var unavailable bool
+	err = db.db.QueryRow(
+		`SELECT DoujinPages.page_path, DoujinPages.archive_entry, DoujinPages.content_hash, Doujins.unavailable
+		 FROM DoujinPages JOIN Doujins ON Doujins.id = DoujinPages.doujin_id
+		 WHERE DoujinPages.id = ?`,
+		pageId,
+	).Scan(&pageFile.Path, &pageFile.ArchiveEntry, &contentHash, &unavailable)
+
+	if err == sql.ErrNoRows {
+		return PageFile{}, DatabaseErrorInvalidId
+	}
+
+	if err != nil {
+		return PageFile{}, err
+	}
+
+	if unavailable {
+		return PageFile{}, DatabaseErrorUnavailableDoujin
+	}
+
+	cachedPath, _, err := db.pageThumbnail(pageFile, contentHash, size)
+	if err != nil {
+		if _, statErr := os.Stat(pageFile.Path); statErr != nil {
+			return PageFile{}, err
+		}
+
+		if !errors.Is(err, image.ErrFormat) {
+			log.Printf("Failed to generate thumbnail of page `%s`: %v\n", pageFile, err)
+		}
+		return pageFile, nil
+	}
+
+	return PageFile{Path: cachedPath}, nil
+}
+
+type GenerateThumbnailsResult struct {
+	Generated int
+	Cached    int
+
+	// Pages in formats that can't be decoded, which are served as they are
+	Unsupported int
+	Failures    []BulkImportFailure
+}
+
+// Generates the thumbnails of every configured size for the covers of every
+// available doujin, or for all of their pages if allPages is true.
+func (db *Database) GenerateThumbnails(allPages bool, progress func(done int, total int)) (GenerateThumbnailsResult, error) {
+	rows, err := db.db.Query(
+		`SELECT DoujinPages.page_path, DoujinPages.archive_entry, DoujinPages.content_hash
+		 FROM DoujinPages JOIN Doujins ON Doujins.id = DoujinPages.doujin_id
+		 WHERE Doujins.unavailable = 0 AND (? OR DoujinPages.page_number = 1)
+		 ORDER BY DoujinPages.doujin_id, DoujinPages.page_number`,
+		allPages,
+	)
+	if err != nil {
+		return GenerateThumbnailsResult{}, err
+	}
+
+	pageFiles := []PageFile{}
+	contentHashes := []string{}
+	for rows.Next() {
+		var pageFile PageFile
+		var contentHash string
+		err = rows.Scan(&pageFile.Path, &pageFile.ArchiveEntry, &contentHash)
+		if err != nil {
+			rows.Close()
+			return GenerateThumbnailsResult{}, err
+		}
+
+		pageFiles = append(pageFiles, pageFile)
+		contentHashes = append(contentHashes, contentHash)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return GenerateThumbnailsResult{}, err
+	}
+
+	result := GenerateThumbnailsResult{Failures: []BulkImportFailure{}}
+	for i, pageFile := range pageFiles {
+		if progress != nil {
+			progress(i, len(pageFiles))
+		}
+
+		for _, size := range db.serverConfig.ThumbnailSizes {
+			_, generated, err := db.pageThumbnail(pageFile, contentHashes[i], size)
+			if errors.Is(err, image.ErrFormat) {
+				result.Unsupported++
+				break
+			}
+
+			if err != nil {
+				result.Failures = append(result.Failures, BulkImportFailure{pageFile.String(), err.Error()})
+				break
+			}
+
+			if generated {
+				result.Generated++
+			} else {
+				result.Cached++
+			}
+		}
+	}
+
+	if progress != nil {
+		progress(len(pageFiles), len(pageFiles))
+	}
+
+	return result, nil
+}
@@ -0,0 +1,308 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+)
+
+// A change made to a field of a doujin. Scalar fields have a single removed
+// and a single added value; list fields have the items removed from and added
+// to the list.
+type DoujinChange struct {
+	Field   string
+	Removed []string
+	Added   []string
+}
+
+func diffScalar(changes []DoujinChange, field string, old string, new string) []DoujinChange {
+	if old == new {
+		return changes
+	}
+	return append(changes, DoujinChange{field, []string{old}, []string{new}})
+}
+
+func diffList(changes []DoujinChange, field string, old []string, new []string) []DoujinChange {
+	removed := []string{}
+	for _, value := range old {
+		if !slices.Contains(new, value) {
+			removed = append(removed, value)
+		}
+	}
+
+	added := []string{}
+	for _, value := range new {
+		if !slices.Contains(old, value) {
+			added = append(added, value)
+		}
+	}
+
+	if len(removed) == 0 && len(added) == 0 {
+		return changes
+	}
+	return append(changes, DoujinChange{field, removed, added})
+}
+
+type storedDoujin struct {
+	Metadata    DoujinImportMetadata
+	UploadDate  string
+	ContentHash string
+	Pages       []importPage
+	PageIds     []int
+}
+
+func getStoredDoujin(tx *sql.Tx, doujinId int) (storedDoujin, error) {
+	var doujin storedDoujin
+	var tagsJson, charactersJson, artistsJson, groupsJson, languagesJson string
+	err := tx.QueryRow(
+		`SELECT title, subtitle, upload_date, external_rating, tags, characters, artists, groups, languages, pages, content_hash
+		 FROM Doujins WHERE id = ?`,
+		doujinId,
+	).Scan(
+		&doujin.Metadata.Title, &doujin.Metadata.Subtitle, &doujin.UploadDate, &doujin.Metadata.ExternalRating,
+		&tagsJson, &charactersJson, &artistsJson, &groupsJson, &languagesJson,
+		&doujin.Metadata.Pages, &doujin.ContentHash,
+	)
+
+	if err == sql.ErrNoRows {
+		return storedDoujin{}, DatabaseErrorInvalidId
+	}
+
+	if err != nil {
+		return storedDoujin{}, err
+	}
+
+	for _, list := range []struct {
+		json string
+		out  *[]string
+	}{
+		{tagsJson, &doujin.Metadata.Tags},
+		{charactersJson, &doujin.Metadata.Characters},
+		{artistsJson, &doujin.Metadata.Artists},
+		{groupsJson, &doujin.Metadata.Groups},
+		{languagesJson, &doujin.Metadata.Languages},
+	} {
+		err = json.Unmarshal([]byte(list.json), list.out)
+		if err != nil {
+			return storedDoujin{}, fmt.Errorf("Got invalid JSON from database")
+		}
+	}
+
+	rows, err := tx.Query(
+		`SELECT id, page_number, page_path, archive_entry, content_hash FROM DoujinPages WHERE doujin_id = ? ORDER BY page_number`,
+		doujinId,
+	)
+	if err != nil {
+		return storedDoujin{}, err
+	}
+	defer rows.Close()
+
+	doujin.Pages = []importPage{}
+	doujin.PageIds = []int{}
+	for rows.Next() {
+		var pageId int
+		var page importPage
+		err = rows.Scan(&pageId, &page.Number, &page.File.Path, &page.File.ArchiveEntry, &page.ContentHash)
+		if err != nil {
+			return storedDoujin{}, err
+		}
+		doujin.Pages = append(doujin.Pages, page)
+		doujin.PageIds = append(doujin.PageIds, pageId)
+	}
+
+	return doujin, rows.Err()
+}
+
+// Replaces the metadata of the doujin with ID doujinId with the metadata found
+// in sourcePath, which is either a doujin folder or archive, or a metadata
+// file (see readMetadataFile). If rescan is true, sourcePath must be a doujin
+// folder or archive, and the doujin's pages are replaced too. Returns the
+// changes made.
+func (db *Database) UpdateDoujin(doujinId int, sourcePath string, rescan bool, options ImportOptions) ([]DoujinChange, error) {
+	var doujin doujinImport
+	var contentHash string
+	committed := false
+
+	info, err := os.Stat(sourcePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() || isArchivePath(sourcePath) {
+		source, err := openDoujinSource(sourcePath)
+		if err != nil {
+			return nil, err
+		}
+		defer source.Close()
+
+		if rescan {
+			doujin, err = scanDoujin(source, options)
+			if err != nil {
+				return nil, err
+			}
+
+			var libraryFiles pendingLibraryFiles
+			contentHash, libraryFiles, err = db.prepareImportPages(source, doujin.Pages)
+			defer func() {
+				db.releaseLibraryFiles(libraryFiles, committed)
+			}()
+			if err != nil {
+				return nil, err
+			}
+		} else {
+			doujin.Metadata, err = readDoujinImportMetadata(source, options.MetadataFormat)
+			if err != nil {
+				return nil, err
+			}
+		}
+	} else {
+		if rescan {
+			return nil, fmt.Errorf("Pages can only be rescanned from a folder or archive")
+		}
+
+		doujin.Metadata, err = readMetadataFile(os.DirFS(filepath.Dir(sourcePath)), filepath.Base(sourcePath), sourcePath, options.MetadataFormat)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	problems := validateImportMetadata(doujin.Metadata)
+	if len(problems) > 0 {
+		return nil, &ValidationError{problems}
+	}
+
+	tx, err := db.db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := getStoredDoujin(tx, doujinId)
+	if err != nil {
+		return nil, err
+	}
+
+	if !rescan && doujin.Metadata.Pages != len(old.Pages) {
+		return nil, fmt.Errorf("The metadata has %d pages, but the doujin has %d (rescan the pages to change them)", doujin.Metadata.Pages, len(old.Pages))
+	}
+
+	newMeta := doujin.Metadata
+	uploadDate := formatUploadDate(newMeta.UploadDate)
+
+	changes := []DoujinChange{}
+	changes = diffScalar(changes, "title", old.Metadata.Title, newMeta.Title)
+	changes = diffScalar(changes, "subtitle", old.Metadata.Subtitle, newMeta.Subtitle)
+	changes = diffScalar(changes, "upload_date", old.UploadDate, uploadDate)
+	changes = diffScalar(changes, "external_rating", strconv.Itoa(old.Metadata.ExternalRating), strconv.Itoa(newMeta.ExternalRating))
+	changes = diffList(changes, "tags", old.Metadata.Tags, newMeta.Tags)
+	changes = diffList(changes, "characters", old.Metadata.Characters, newMeta.Characters)
+	changes = diffList(changes, "artists", old.Metadata.Artists, newMeta.Artists)
+	changes = diffList(changes, "groups", old.Metadata.Groups, newMeta.Groups)
+	changes = diffList(changes, "languages", old.Metadata.Languages, newMeta.Languages)
+	changes = diffScalar(changes, "pages", strconv.Itoa(old.Metadata.Pages), strconv.Itoa(newMeta.Pages))
+
+	_, err = tx.Exec(
+		`UPDATE Doujins SET
+			title = ?, subtitle = ?, upload_date = ?, external_rating = ?,
+			tags = ?, characters = ?, artists = ?, groups = ?, languages = ?, pages = ?
+		 WHERE id = ?`,
+		newMeta.Title, newMeta.Subtitle, uploadDate, newMeta.ExternalRating,
+		jsonEncode(newMeta.Tags), jsonEncode(newMeta.Characters), jsonEncode(newMeta.Artists),
+		jsonEncode(newMeta.Groups), jsonEncode(newMeta.Languages), newMeta.Pages,
+		doujinId,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	if rescan {
+		for i := range max(len(old.Pages), len(doujin.Pages)) {
+			var oldPage, newPage string
+			if i < len(old.Pages) {
+				oldPage = old.Pages[i].File.String()
+				if old.Pages[i].ContentHash != "" {
+					oldPage += " (" + old.Pages[i].ContentHash[:12] + ")"
+				}
+			}
+			if i < len(doujin.Pages) {
+				newPage = doujin.Pages[i].File.String() + " (" + doujin.Pages[i].ContentHash[:12] + ")"
+			}
+
+			field := fmt.Sprintf("page %d", i+1)
+			switch {
+			case oldPage == "":
+				changes = append(changes, DoujinChange{field, []string{}, []string{newPage}})
+			case newPage == "":
+				changes = append(changes, DoujinChange{field, []string{oldPage}, []string{}})
+			default:
+				changes = diffScalar(changes, field, oldPage, newPage)
+			}
+		}
+
+		// Pages are replaced in place so they keep their IDs, which clients
+		// may have stored, like the covers of doujins
+		for i, pageId := range old.PageIds[:min(len(old.PageIds), len(doujin.Pages))] {
+			err = updateDoujinPage(tx, pageId, doujin.Pages[i])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(doujin.Pages) > len(old.PageIds) {
+			err = insertDoujinPages(tx, int64(doujinId), doujin.Pages[len(old.PageIds):])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, pageId := range old.PageIds[min(len(old.PageIds), len(doujin.Pages)):] {
+			_, err = tx.Exec(`DELETE FROM DoujinPages WHERE id = ?`, pageId)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// The doujin's pages can be read again if fsck marked it as
+		// unavailable
+		_, err = tx.Exec(`UPDATE Doujins SET content_hash = ?, unavailable = 0 WHERE id = ?`, contentHash, doujinId)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, err
+	}
+	committed = true
+
+	// Files of the old pages that no other page uses anymore
+	if rescan {
+		oldLibraryFiles := []string{}
+		for _, page := range old.Pages {
+			if page.File.ArchiveEntry == "" && db.isManagedPath(page.File.Path) {
+				oldLibraryFiles = append(oldLibraryFiles, page.File.Path)
+			}
+		}
+		db.removeUnusedLibraryFiles(oldLibraryFiles)
+	}
+
+	return changes, nil
+}
+
+func updateDoujinPage(tx *sql.Tx, pageId int, page importPage) error {
+	_, err := tx.Exec(
+		`UPDATE DoujinPages SET
+			page_path = ?, archive_entry = ?, page_number = ?, content_hash = ?, perceptual_hash = ?,
+			width = ?, height = ?, size = ?, mime_type = ?, original_name = ?
+		 WHERE id = ?`,
+		page.File.Path, page.File.ArchiveEntry, page.Number, page.ContentHash, page.PerceptualHash,
+		page.Info.Width, page.Info.Height, page.Info.Size, page.Info.MimeType, page.Name,
+		pageId,
+	)
+	return err
+}
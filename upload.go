@@ -0,0 +1,248 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"io"
+	"mime/multipart"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Form values are short, so anything longer is rejected instead of being
+// read into memory.
+const MaxUploadFormValueLength = 256
+
+// Writes the file in part to filePath, failing with
+// DatabaseErrorUploadTooLarge once more than *remaining bytes were written.
+func saveUploadedFile(part *multipart.Part, filePath string, remaining *int64) error {
+	file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+
+	written, err := io.Copy(file, io.LimitReader(part, *remaining+1))
+	if err == nil && written > *remaining {
+		err = DatabaseErrorUploadTooLarge
+	}
+	*remaining -= written
+
+	closeErr := file.Close()
+	if err != nil {
+		return err
+	}
+	return closeErr
+}
+
+// Returns the name an uploaded file is saved as, which is the name it was
+// uploaded with. Names that can't be saved as they are, like "..", are
+// refused.
+func uploadedFileName(part *multipart.Part) (string, error) {
+	name := path.Base(strings.ReplaceAll(part.FileName(), "\\", "/"))
+	if name == "" || name == "." || name == "/" || strings.HasPrefix(name, ".") {
+		return "", DatabaseErrorInvalidUpload
+	}
+	return name, nil
+}
+
+// Metadata files are saved under the name of a metadata file of their format,
+// whatever they were uploaded as, so they're found when importing.
+func uploadedMetadataName(name string) string {
+	if strings.EqualFold(path.Ext(name), ".xml") {
+		return "ComicInfo.xml"
+	}
+	return "metadata.json"
+}
+
+func checkArchiveSize(archivePath string, maxSize int64) error {
+	archive, err := zip.OpenReader(archivePath)
+	if err != nil {
+		// Reported as a validation problem when importing
+		return nil
+	}
+	defer archive.Close()
+
+	var size uint64
+	for _, file := range archive.File {
+		size += file.UncompressedSize64
+		if size > uint64(maxSize) {
+			return DatabaseErrorUploadTooLarge
+		}
+	}
+	return nil
+}
+
+// Problems are shown to the uploader, who doesn't need to know where the
+// upload is stored, so the paths in them are made relative to uploadPath.
+func relativeValidationError(err *ValidationError, uploadPath string) *ValidationError {
+	problems := []error{}
+	for _, problem := range err.Problems {
+		message := strings.ReplaceAll(problem.Error(), uploadPath+string(filepath.Separator), "")
+		message = strings.ReplaceAll(message, uploadPath, ".")
+		problems = append(problems, errors.New(message))
+	}
+	return &ValidationError{problems}
+}
+
+// Imports a doujin uploaded as multipart form data by an admin, and returns
+// its ID. The form has either an "archive" file, a CBZ/ZIP archive, or a
+// "metadata" file and "page" files, like the contents of a doujin's folder.
+// It can also have "metadata_format" and "natural_sort" values, with the
+// meaning of the fields of ImportOptions.
+//
+// Files are written to a staging directory inside the upload path, and are
+// deleted unless the import succeeds. Doujins whose pages aren't moved to the
+// library stay in the upload path after being imported, as their pages are
+// read from there. Invalid doujins fail with a *ValidationError.
+func (db *Database) UploadDoujin(username string, token string, form *multipart.Reader) (int, error) {
+	_, err := db.authenticateAdmin(username, token)
+	if err != nil {
+		return 0, err
+	}
+
+	stagingPath := filepath.Join(db.serverConfig.UploadPath, ".staging")
+	err = os.MkdirAll(stagingPath, 0755)
+	if err != nil {
+		return 0, err
+	}
+
+	uploadPath, err := os.MkdirTemp(stagingPath, "upload-*")
+	if err == nil {
+		err = os.Chmod(uploadPath, 0755)
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer func() {
+		if uploadPath != "" {
+			os.RemoveAll(uploadPath)
+		}
+	}()
+
+	maxSize := int64(db.serverConfig.UploadMaxSizeMB) * 1024 * 1024
+	remaining := maxSize
+	options := ImportOptions{}
+	archiveName := ""
+	hasMetadata := false
+	savedNames := []string{}
+
+	for {
+		part, err := form.NextPart()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return 0, DatabaseErrorInvalidUpload
+		}
+
+		if part.FileName() == "" {
+			value, err := io.ReadAll(io.LimitReader(part, MaxUploadFormValueLength+1))
+			if err != nil || len(value) > MaxUploadFormValueLength {
+				return 0, DatabaseErrorInvalidUpload
+			}
+
+			switch part.FormName() {
+			case "metadata_format":
+				options.MetadataFormat = string(value)
+				if options.MetadataFormat != "" && metadataAdapterByName(options.MetadataFormat) == nil {
+					return 0, DatabaseErrorInvalidUpload
+				}
+			case "natural_sort":
+				options.NaturalSort, err = strconv.ParseBool(string(value))
+				if err != nil {
+					return 0, DatabaseErrorInvalidUpload
+				}
+			default:
+				return 0, DatabaseErrorInvalidUpload
+			}
+			continue
+		}
+
+		name, err := uploadedFileName(part)
+		if err != nil {
+			return 0, err
+		}
+
+		switch part.FormName() {
+		case "archive":
+			if archiveName != "" || !isArchivePath(name) {
+				return 0, DatabaseErrorInvalidUpload
+			}
+			archiveName = name
+		case "metadata":
+			if hasMetadata {
+				return 0, DatabaseErrorInvalidUpload
+			}
+			hasMetadata = true
+			name = uploadedMetadataName(name)
+		case "page":
+			if slices.Contains(metadataFileNames, name) {
+				return 0, DatabaseErrorInvalidUpload
+			}
+		default:
+			return 0, DatabaseErrorInvalidUpload
+		}
+
+		if slices.Contains(savedNames, name) {
+			return 0, DatabaseErrorInvalidUpload
+		}
+		savedNames = append(savedNames, name)
+
+		err = saveUploadedFile(part, filepath.Join(uploadPath, name), &remaining)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// Either an archive alone or a metadata file and pages
+	if (archiveName != "") == hasMetadata || (archiveName != "" && len(savedNames) > 1) || (hasMetadata && len(savedNames) < 2) {
+		return 0, DatabaseErrorInvalidUpload
+	}
+
+	if archiveName != "" {
+		err = checkArchiveSize(filepath.Join(uploadPath, archiveName), maxSize)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if !db.isLibraryEnabled() {
+		// Pages will be read from where they're imported, so they're moved
+		// out of the staging directory first
+		finalPath := filepath.Join(db.serverConfig.UploadPath, filepath.Base(uploadPath))
+		err = os.Rename(uploadPath, finalPath)
+		if err != nil {
+			return 0, err
+		}
+		uploadPath = finalPath
+	}
+
+	// Staged uploads are deleted once their pages are in the library
+	options.Temporary = db.isLibraryEnabled()
+
+	sourcePath := uploadPath
+	if archiveName != "" {
+		sourcePath = filepath.Join(uploadPath, archiveName)
+	}
+
+	doujinId, err := db.ImportDoujin(sourcePath, options)
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return 0, relativeValidationError(validationErr, uploadPath)
+	}
+
+	if err != nil {
+		return 0, err
+	}
+
+	if !db.isLibraryEnabled() {
+		uploadPath = ""
+	}
+
+	return doujinId, nil
+}
@@ -0,0 +1,354 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// A ValidationError holds every problem found in a doujin that can't be
+// imported.
+type ValidationError struct {
+	Problems []error
+}
+
+func (err *ValidationError) Error() string {
+	problems := []string{}
+	for _, problem := range err.Problems {
+		problems = append(problems, problem.Error())
+	}
+	return strings.Join(problems, "; ")
+}
+
+func (err *ValidationError) Unwrap() []error {
+	return err.Problems
+}
+
+// Appends the problems in err to problems, flattening ValidationErrors.
+func appendProblems(problems []error, err error) []error {
+	var validationErr *ValidationError
+	if errors.As(err, &validationErr) {
+		return append(problems, validationErr.Problems...)
+	}
+	return append(problems, err)
+}
+
+type importPage struct {
+	Number         int
+	Name           string
+	File           PageFile
+	ContentHash    string
+	PerceptualHash sql.NullInt64
+	Info           PageInfo
+}
+
+// How doujins are read and stored when importing them.
+type ImportOptions struct {
+	// Name of the metadata adapter to use. Detected if empty.
+	MetadataFormat string
+
+	// Whether every image file is a page, in natural order, instead of only
+	// the files named after their page number. Ignored for doujins whose
+	// metadata lists their page files.
+	NaturalSort bool
+
+	// Series the doujin is a chapter of, with number ChapterNumber, or 0 if
+	// it isn't part of a series.
+	SeriesId      int
+	ChapterNumber int
+
+	// Whether the source is deleted once it's imported, like staged uploads,
+	// so it isn't written to the import log.
+	Temporary bool
+}
+
+type doujinImport struct {
+	Metadata DoujinImportMetadata
+	Pages    []importPage // sorted by page number
+}
+
+func validateImportMetadata(doujinMeta DoujinImportMetadata) []error {
+	problems := []error{}
+
+	if strings.TrimSpace(doujinMeta.Title) == "" {
+		problems = append(problems, fmt.Errorf("The title is empty"))
+	}
+
+	if doujinMeta.Pages < 1 {
+		problems = append(problems, fmt.Errorf("The number of pages must be greater than 0 (got %d)", doujinMeta.Pages))
+	}
+
+	return problems
+}
+
+func checkPageFile(source *doujinSource, name string) error {
+	extensionType := imageTypeFromExtension(name)
+	if extensionType == "" {
+		return fmt.Errorf("Page `%s` does not have the extension of a supported image type", source.PageFile(name))
+	}
+
+	file, err := source.fsys.Open(name)
+	if err != nil {
+		return fmt.Errorf("Failed to open page `%s`: %w", source.PageFile(name), err)
+	}
+	defer file.Close()
+
+	header, err := readImageMagic(file)
+	if err != nil {
+		return fmt.Errorf("Failed to read page `%s`: %w", source.PageFile(name), err)
+	}
+
+	magicType := imageTypeFromMagic(header)
+	if magicType == "" {
+		return fmt.Errorf("Page `%s` is not a supported image", source.PageFile(name))
+	}
+
+	if magicType != extensionType {
+		return fmt.Errorf("Page `%s` has the extension of %s but contains %s", source.PageFile(name), extensionType, magicType)
+	}
+
+	return nil
+}
+
+// Compares file names the way people expect them to be ordered, with runs
+// of digits compared by their value ("2.png" comes before "10.png") and
+// letters compared regardless of case.
+func naturalCompare(a string, b string) int {
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		if isDigit(a[i]) && isDigit(b[j]) {
+			startA, startB := i, j
+			for i < len(a) && isDigit(a[i]) {
+				i++
+			}
+			for j < len(b) && isDigit(b[j]) {
+				j++
+			}
+
+			numberA := strings.TrimLeft(a[startA:i], "0")
+			numberB := strings.TrimLeft(b[startB:j], "0")
+			if len(numberA) != len(numberB) {
+				return len(numberA) - len(numberB)
+			}
+			if c := strings.Compare(numberA, numberB); c != 0 {
+				return c
+			}
+			continue
+		}
+
+		runeA, sizeA := utf8.DecodeRuneInString(a[i:])
+		runeB, sizeB := utf8.DecodeRuneInString(b[j:])
+		if c := int(unicode.ToLower(runeA)) - int(unicode.ToLower(runeB)); c != 0 {
+			return c
+		}
+		i += sizeA
+		j += sizeB
+	}
+
+	if c := (len(a) - i) - (len(b) - j); c != 0 {
+		return c
+	}
+	return strings.Compare(a, b)
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// Pages named after their page number, like "01.png". Every other file is
+// ignored.
+func numberedPages(source *doujinSource, entries []fs.DirEntry) ([]importPage, []error) {
+	problems := []error{}
+
+	pages := []importPage{}
+	for _, e := range entries {
+		pageNumber := pageNameToPageNumber(e.Name())
+		if e.IsDir() || pageNumber == -1 {
+			continue
+		}
+
+		err := checkPageFile(source, e.Name())
+		if err != nil {
+			problems = append(problems, err)
+		}
+
+		pages = append(pages, importPage{
+			Number: pageNumber,
+			Name:   e.Name(),
+			File:   source.PageFile(e.Name()),
+		})
+	}
+
+	slices.SortStableFunc(pages, func(a, b importPage) int {
+		return a.Number - b.Number
+	})
+
+	expectedPageNumber := 1
+	for i, page := range pages {
+		if i > 0 && pages[i-1].Number == page.Number {
+			problems = append(problems, fmt.Errorf("Page %d appears more than once (`%s` and `%s`)", page.Number, pages[i-1].Name, page.Name))
+			continue
+		}
+
+		if page.Number != expectedPageNumber {
+			problems = append(problems, fmt.Errorf("The pages in the folder are not sequential (expected page %d, found %d)", expectedPageNumber, page.Number))
+		}
+		expectedPageNumber = page.Number + 1
+	}
+
+	return pages, problems
+}
+
+// Every image file in natural order (see naturalCompare), whatever its name.
+// Hidden files, like the "._" files macOS leaves in archives, are ignored.
+func naturallySortedPages(source *doujinSource, entries []fs.DirEntry) ([]importPage, []error) {
+	problems := []error{}
+
+	names := []string{}
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") || imageTypeFromExtension(e.Name()) == "" {
+			continue
+		}
+		names = append(names, e.Name())
+	}
+	slices.SortFunc(names, naturalCompare)
+
+	pages := []importPage{}
+	for i, name := range names {
+		err := checkPageFile(source, name)
+		if err != nil {
+			problems = append(problems, err)
+		}
+
+		pages = append(pages, importPage{
+			Number: i + 1,
+			Name:   name,
+			File:   source.PageFile(name),
+		})
+	}
+
+	return pages, problems
+}
+
+// The files listed in the metadata's "page_files", in that order.
+func listedPages(source *doujinSource, names []string) ([]importPage, []error) {
+	problems := []error{}
+
+	pages := []importPage{}
+	for i, name := range names {
+		if !fs.ValidPath(name) || name == "." {
+			problems = append(problems, fmt.Errorf("Page %d has an invalid file name (`%s`)", i+1, name))
+			continue
+		}
+
+		if previous := slices.Index(names[:i], name); previous != -1 {
+			problems = append(problems, fmt.Errorf("File `%s` is listed as page %d and page %d", name, previous+1, i+1))
+			continue
+		}
+
+		if _, err := fs.Stat(source.fsys, name); err != nil {
+			problems = append(problems, fmt.Errorf("Page %d (`%s`) does not exist", i+1, source.PageFile(name)))
+			continue
+		}
+
+		err := checkPageFile(source, name)
+		if err != nil {
+			problems = append(problems, err)
+		}
+
+		pages = append(pages, importPage{
+			Number: i + 1,
+			Name:   name,
+			File:   source.PageFile(name),
+		})
+	}
+
+	return pages, problems
+}
+
+// Reads and checks everything needed for importing the doujin in source. If
+// the doujin can't be imported, the returned error is a *ValidationError
+// listing every problem found.
+func scanDoujin(source *doujinSource, options ImportOptions) (doujinImport, error) {
+	problems := []error{}
+
+	doujinMeta, err := readDoujinImportMetadata(source, options.MetadataFormat)
+	if err != nil {
+		problems = appendProblems(problems, err)
+	}
+	problems = append(problems, validateImportMetadata(doujinMeta)...)
+
+	var pages []importPage
+	var pageProblems []error
+	foundPages := 0
+	if doujinMeta.PageFiles != nil {
+		// Problems with listed pages are already reported, so only the
+		// length of the list is compared with the number of pages
+		pages, pageProblems = listedPages(source, doujinMeta.PageFiles)
+		foundPages = len(doujinMeta.PageFiles)
+	} else {
+		entries, err := fs.ReadDir(source.fsys, ".")
+		if err != nil {
+			problems = append(problems, fmt.Errorf("Failed to read directory `%s`: %w", source.absolutePath, err))
+			return doujinImport{}, &ValidationError{problems}
+		}
+
+		if options.NaturalSort {
+			pages, pageProblems = naturallySortedPages(source, entries)
+		} else {
+			pages, pageProblems = numberedPages(source, entries)
+		}
+		foundPages = len(pages)
+	}
+	problems = append(problems, pageProblems...)
+
+	if doujinMeta.Pages > 0 {
+		if foundPages < doujinMeta.Pages {
+			problems = append(problems, fmt.Errorf("Some pages are missing in the folder (found %d out of %d)", foundPages, doujinMeta.Pages))
+		}
+
+		if foundPages > doujinMeta.Pages {
+			problems = append(problems, fmt.Errorf("The folder contains to many pages (found %d out of %d)", foundPages, doujinMeta.Pages))
+		}
+	}
+
+	if len(problems) > 0 {
+		return doujinImport{}, &ValidationError{problems}
+	}
+
+	return doujinImport{
+		Metadata: doujinMeta,
+		Pages:    pages,
+	}, nil
+}
+
+// Checks the doujin in the folder or archive at doujinPath with the same
+// rules used by ImportDoujin, without touching the database.
+func ValidateDoujin(doujinPath string, options ImportOptions) error {
+	source, err := openDoujinSource(doujinPath)
+	if err != nil {
+		return &ValidationError{[]error{err}}
+	}
+	defer source.Close()
+
+	_, err = scanDoujin(source, options)
+	return err
+}
+
+// Returns whether the folder at folderPath is a doujin, as opposed to a folder
+// containing doujins.
+func isDoujinFolder(folderPath string) bool {
+	for _, name := range metadataFileNames {
+		if _, err := os.Stat(path.Join(folderPath, name)); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const WatchPollInterval = 10 * time.Second
+
+type pendingImport struct {
+	signature  string
+	lastChange time.Time
+}
+
+// A DirectoryWatcher periodically scans directories for new doujin folders
+// and archives, and imports them once they stop changing. The result of every
+// import is written to the ImportLog table.
+type DirectoryWatcher struct {
+	db          *Database
+	directories []string
+	settleTime  time.Duration
+	pending     map[string]pendingImport
+}
+
+func NewDirectoryWatcher(db *Database, directories []string, settleTime time.Duration) *DirectoryWatcher {
+	return &DirectoryWatcher{
+		db:          db,
+		directories: directories,
+		settleTime:  settleTime,
+		pending:     map[string]pendingImport{},
+	}
+}
+
+// The signature of a folder or archive changes whenever a file inside it is
+// added, removed or modified.
+func sourceSignature(sourcePath string) (string, error) {
+	var count int
+	var size int64
+	var modTime time.Time
+
+	err := filepath.WalkDir(sourcePath, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		count++
+		size += info.Size()
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%d:%d:%d", count, size, modTime.UnixNano()), nil
+}
+
+func (w *DirectoryWatcher) Run() {
+	for {
+		w.Scan()
+		time.Sleep(WatchPollInterval)
+	}
+}
+
+func (w *DirectoryWatcher) Scan() {
+	seen := map[string]bool{}
+
+	for _, directory := range w.directories {
+		entries, err := os.ReadDir(directory)
+		if err != nil {
+			log.Printf("Failed to read watched directory `%s`: %v\n", directory, err)
+			continue
+		}
+
+		for _, e := range entries {
+			if !e.IsDir() && !isArchivePath(e.Name()) {
+				continue
+			}
+
+			sourcePath := filepath.Join(directory, e.Name())
+			seen[sourcePath] = true
+
+			err = w.check(sourcePath)
+			if err != nil {
+				log.Printf("Failed to check `%s` for importing: %v\n", sourcePath, err)
+			}
+		}
+	}
+
+	for sourcePath := range w.pending {
+		if !seen[sourcePath] {
+			delete(w.pending, sourcePath)
+		}
+	}
+}
+
+func (w *DirectoryWatcher) check(sourcePath string) error {
+	signature, err := sourceSignature(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	logEntry, found, err := w.db.GetImportLogEntry(sourcePath)
+	if err != nil {
+		return err
+	}
+
+	// Successful imports are never repeated, and failed ones are only retried
+	// after their contents change.
+	if found && (logEntry.Succeeded() || logEntry.Signature == signature) {
+		return nil
+	}
+
+	pending, ok := w.pending[sourcePath]
+	if !ok || pending.signature != signature {
+		w.pending[sourcePath] = pendingImport{signature, time.Now()}
+		return nil
+	}
+
+	if time.Since(pending.lastChange) < w.settleTime {
+		return nil
+	}
+
+	delete(w.pending, sourcePath)
+
+	doujinId, err := w.db.ImportDoujin(sourcePath, ImportOptions{})
+	errorString := ""
+	if err != nil {
+		errorString = err.Error()
+		log.Printf("Failed to import `%s`: %v\n", sourcePath, err)
+	} else {
+		log.Printf("Imported `%s` as doujin %d\n", sourcePath, doujinId)
+	}
+
+	return w.db.WriteImportLogEntry(ImportLogEntry{
+		SourcePath: sourcePath,
+		Signature:  signature,
+		DoujinId:   doujinId,
+		Error:      errorString,
+		Date:       time.Now(),
+	})
+}